@@ -0,0 +1,58 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func init() {
+	SchemeBuilder.Register(&ClairStack{}, &ClairStackList{})
+}
+
+// ClairStack declares a clair deployment composed by a postgres database, a redis instance and
+// clair itself. All objects are created in the ClairStack namespace and are owned by it, owner
+// references can't cross namespaces.
+type ClairStack struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClairStackSpec   `json:"spec,omitempty"`
+	Status ClairStackStatus `json:"status,omitempty"`
+}
+
+// ClairStackSpec holds the desired state for each one of the stack components.
+type ClairStackSpec struct {
+	Postgres ComponentSpec `json:"postgres,omitempty"`
+	Redis    ComponentSpec `json:"redis,omitempty"`
+	Clair    ComponentSpec `json:"clair,omitempty"`
+}
+
+// ComponentSpec holds the desired state for a component. NamePrefix defaults to the ClairStack
+// name and Overlay defaults to the base overlay.
+type ComponentSpec struct {
+	NamePrefix string `json:"namePrefix,omitempty"`
+	Overlay    string `json:"overlay,omitempty"`
+}
+
+// ClairStackStatus holds the observed state of the stack. Conditions carry one condition per
+// component (e.g. PostgresReady) plus an aggregated Ready condition.
+type ClairStackStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	Components         []ComponentStatus  `json:"components,omitempty"`
+}
+
+// ComponentStatus holds the last observed overlay and readiness of a component.
+type ComponentStatus struct {
+	Name    string `json:"name"`
+	Overlay string `json:"overlay,omitempty"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+}
+
+// ClairStackList is a list of ClairStack objects.
+type ClairStackList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ClairStack `json:"items"`
+}
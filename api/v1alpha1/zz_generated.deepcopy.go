@@ -0,0 +1,89 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto copies the receiver into out.
+func (in *ClairStack) DeepCopyInto(out *ClairStack) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy creates a new ClairStack copying the receiver.
+func (in *ClairStack) DeepCopy() *ClairStack {
+	if in == nil {
+		return nil
+	}
+	out := new(ClairStack)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject copies the receiver, creating a new runtime.Object.
+func (in *ClairStack) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *ClairStackStatus) DeepCopyInto(out *ClairStackStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		out.Conditions = make([]metav1.Condition, len(in.Conditions))
+		for i := range in.Conditions {
+			in.Conditions[i].DeepCopyInto(&out.Conditions[i])
+		}
+	}
+	if in.Components != nil {
+		out.Components = make([]ComponentStatus, len(in.Components))
+		copy(out.Components, in.Components)
+	}
+}
+
+// DeepCopy creates a new ClairStackStatus copying the receiver.
+func (in *ClairStackStatus) DeepCopy() *ClairStackStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClairStackStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *ClairStackList) DeepCopyInto(out *ClairStackList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ClairStack, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+// DeepCopy creates a new ClairStackList copying the receiver.
+func (in *ClairStackList) DeepCopy() *ClairStackList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClairStackList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject copies the receiver, creating a new runtime.Object.
+func (in *ClairStackList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ricardomaraschini/freighter/api/v1alpha1"
+	"github.com/ricardomaraschini/freighter/ctrls/clairstack"
+	"github.com/ricardomaraschini/freighter/ctrls/manifest"
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// setup creates a client for the cluster and builds the stack according to the options.
+func setup(ctx context.Context, opts *options) (*app, error) {
+	selected, err := selectComponents(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := opts.restConfig()
+	if err != nil {
+		return nil, &clusterError{err: err}
+	}
+
+	cli, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return nil, &clusterError{err: err}
+	}
+	return newApp(ctx, cli, opts, selected)
+}
+
+// overlayArg returns the overlay provided as the first argument, BaseOverlay if none.
+func overlayArg(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		return mctrl.BaseOverlay, nil
+	case 1:
+		return args[0], nil
+	default:
+		return "", usageErrorf("too many arguments")
+	}
+}
+
+// noArgs returns an usage error if any argument has been provided.
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments %v", args)
+	}
+	return nil
+}
+
+// deploy moves the selected components to the base overlay and waits until they are ready.
+func deploy(ctx context.Context, opts *options, args []string) error {
+	return moveTo(ctx, opts, args, mctrl.BaseOverlay)
+}
+
+// scaleDown moves the selected components to the scale-down overlay.
+func scaleDown(ctx context.Context, opts *options, args []string) error {
+	return moveTo(ctx, opts, args, mctrl.ScaleDownOverlay)
+}
+
+// moveTo recovers the stack state and moves the selected components to the provided overlay.
+// Components not selected are left untouched. Reports all rewritten images, if any.
+func moveTo(ctx context.Context, opts *options, args []string, overlay string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+
+	app, err := setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if _, err := app.stack.Recover(ctx); err != nil {
+		return err
+	}
+
+	logr.FromContextOrDiscard(ctx).Info(
+		"moving components", "components", app.selected, mctrl.LogKeyOverlay, overlay,
+	)
+	if _, err := app.stack.ApplyOverlays(ctx, app.overlays(overlay)); err != nil {
+		return err
+	}
+
+	logRewrites(ctx, app.rewriter)
+	return nil
+}
+
+// apply loads the stack manifest provided through -f and moves each one of its components to
+// the overlay the manifest determines. If components are selected through -component only those
+// are moved, they are referred by their names in the manifest.
+func apply(ctx context.Context, opts *options, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	if opts.file == "" {
+		return usageErrorf("missing stack manifest (-f)")
+	}
+
+	man, err := manifest.LoadFile(opts.file)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range opts.components {
+		if man.Component(name) == nil {
+			return usageErrorf("component %q not found in manifest", name)
+		}
+	}
+
+	cfg, err := opts.restConfig()
+	if err != nil {
+		return &clusterError{err: err}
+	}
+
+	cli, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return &clusterError{err: err}
+	}
+
+	stack, overlays, err := man.Build(cli)
+	if err != nil {
+		return err
+	}
+	if len(opts.components) > 0 {
+		selected := map[string]string{}
+		for _, name := range opts.components {
+			selected[name] = overlays[name]
+		}
+		overlays = selected
+	}
+	stack.SetWaitOptions(waitOptions(ctx, opts))
+
+	rewriter := opts.rewriter()
+	if rewriter != nil {
+		if err := stack.AddOMutator(rewriter.Mutate); err != nil {
+			return fmt.Errorf("error registering image rewriter: %w", err)
+		}
+	}
+
+	if _, err := stack.Recover(ctx); err != nil {
+		return err
+	}
+
+	logr.FromContextOrDiscard(ctx).Info("applying manifest", "file", opts.file)
+	if _, err := stack.ApplyOverlays(ctx, overlays); err != nil {
+		return err
+	}
+
+	logRewrites(ctx, rewriter)
+	return nil
+}
+
+// logRewrites logs all images rewritten by the rewriter, if any.
+func logRewrites(ctx context.Context, rewriter *mctrl.ImageRewriter) {
+	if rewriter == nil {
+		return
+	}
+
+	logger := logr.FromContextOrDiscard(ctx)
+	for _, rw := range rewriter.Rewrites() {
+		logger.Info(
+			"image rewritten",
+			"object", rw.Ref.String(),
+			"container", rw.Container,
+			"from", rw.From,
+			"to", rw.To,
+		)
+	}
+}
+
+// status prints the status of each selected component. Returns errNotReady if any of them is
+// not ready.
+func status(ctx context.Context, opts *options, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+
+	app, err := setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if _, err := app.stack.Recover(ctx); err != nil {
+		return err
+	}
+
+	ready := true
+	for _, name := range components {
+		if !app.isSelected(name) {
+			continue
+		}
+
+		mc := app.mctrls[name]
+		if mc.Overlay() == mctrl.NotAppliedOverlay {
+			fmt.Fprintf(os.Stdout, "%s: not applied\n", name)
+			ready = false
+			continue
+		}
+
+		st, err := mc.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("error reading %q status: %w", name, err)
+		}
+
+		state := "ready"
+		if !st.Ready {
+			state = "not ready"
+			ready = false
+		}
+		fmt.Fprintf(os.Stdout, "%s (%s): %s: %s\n", name, mc.Overlay(), state, st.Message)
+	}
+
+	if !ready {
+		return errNotReady
+	}
+	return nil
+}
+
+// advertise recovers the stack state from the cluster and prints the data advertised by the
+// selected components. Sensitive data (e.g. passwords) is redacted unless -show-sensitive is set.
+func advertise(ctx context.Context, opts *options, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+
+	app, err := setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	ads, err := app.stack.Recover(ctx)
+	if err != nil {
+		return err
+	}
+
+	values := ads.Redact()
+	if opts.showSensitive {
+		values = ads.Snapshot()
+	}
+
+	for _, idx := range ads.Keys() {
+		if !app.isSelected(ads.Provider(idx)) {
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s=%s\n", idx, values[idx])
+	}
+	return nil
+}
+
+// render prints, as a multi document yaml, all objects the selected components would create for
+// the provided overlay. Nothing is written to the cluster.
+func render(ctx context.Context, opts *options, args []string) error {
+	overlay, err := overlayArg(args)
+	if err != nil {
+		return err
+	}
+
+	app, err := setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	objs, err := app.stack.RenderSelected(ctx, overlay, app.selected)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objs {
+		dt, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "---\n%s", dt)
+	}
+	return nil
+}
+
+// diff prints the differences between the cluster state and the state after the selected
+// components are moved to the provided overlay. Returns errDiffers if there are differences.
+// Secret data is redacted unless -show-sensitive is set.
+func diff(ctx context.Context, opts *options, args []string) error {
+	overlay, err := overlayArg(args)
+	if err != nil {
+		return err
+	}
+
+	app, err := setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.showSensitive {
+		ctx = mctrl.ShowSensitive(ctx)
+	}
+
+	diffs, err := app.stack.DiffSelected(ctx, overlay, app.selected)
+	if err != nil {
+		return err
+	}
+
+	for _, dif := range diffs {
+		fmt.Fprint(os.Stdout, dif.Diff)
+	}
+
+	if len(diffs) > 0 {
+		return errDiffers
+	}
+	return nil
+}
+
+// images prints all images the selected components would pull when moved to any of the provided
+// overlays, one per line. By default every overlay the selected components provide is inspected.
+// Images are printed after being rewritten (see -mirror and -digest flags).
+func images(ctx context.Context, opts *options, args []string) error {
+	app, err := setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	imgs, err := app.stack.ImagesSelected(ctx, app.selected, args...)
+	if err != nil {
+		return err
+	}
+
+	for _, img := range imgs {
+		fmt.Fprintln(os.Stdout, img)
+	}
+	return nil
+}
+
+// destroy deletes everything created by the selected components.
+func destroy(ctx context.Context, opts *options, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+
+	app, err := setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	logr.FromContextOrDiscard(ctx).Info("destroying components", "components", app.selected)
+	return app.stack.Destroy(ctx, app.selected...)
+}
+
+// operator runs freighter in operator mode, reconciling ClairStack objects. The ClairStack CRD
+// must be installed, see config/crd, and the operator needs the permissions in config/rbac.
+func operator(ctx context.Context, opts *options, args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+
+	cfg, err := opts.restConfig()
+	if err != nil {
+		return &clusterError{err: err}
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return err
+	}
+
+	mgr, err := ctrl.NewManager(
+		cfg, ctrl.Options{Scheme: scheme, MetricsBindAddress: opts.metricsAddr},
+	)
+	if err != nil {
+		return &clusterError{err: err}
+	}
+
+	if err := clairstack.NewReconciler(mgr.GetClient()).SetupWithManager(mgr); err != nil {
+		return fmt.Errorf("error setting up reconciler: %w", err)
+	}
+
+	logr.FromContextOrDiscard(ctx).Info("starting operator")
+	return mgr.Start(ctrl.SetupSignalHandler())
+}
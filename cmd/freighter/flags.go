@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// pairsFlag is a repeatable flag holding key=value pairs, order is kept.
+type pairsFlag [][2]string
+
+// String returns the pairs in the key=value format, comma separated.
+func (p *pairsFlag) String() string {
+	var pairs []string
+	for _, pair := range *p {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", pair[0], pair[1]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set parses and appends a key=value pair.
+func (p *pairsFlag) Set(val string) error {
+	parts := strings.SplitN(val, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("%q is not in the key=value format", val)
+	}
+	*p = append(*p, [2]string{parts[0], parts[1]})
+	return nil
+}
+
+// listFlag is a repeatable flag holding a list of values, values can also be comma separated.
+type listFlag []string
+
+// String returns the values comma separated.
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set appends all comma separated values.
+func (l *listFlag) Set(val string) error {
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// options holds the flags shared by all commands.
+type options struct {
+	namespace     string
+	prefix        string
+	kubeconfig    string
+	kubecontext   string
+	components    listFlag
+	timeout       time.Duration
+	mirrors       pairsFlag
+	digests       pairsFlag
+	pinDigests    bool
+	file          string
+	metricsAddr   string
+	verbosity     int
+	showSensitive bool
+}
+
+// flagSet returns a flag set for the provided command, flags are parsed into 'opts'.
+func flagSet(cmd string, opts *options) *flag.FlagSet {
+	fset := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fset.StringVar(&opts.namespace, "namespace", "default", "namespace where the stack lives")
+	fset.StringVar(&opts.prefix, "prefix", "clair", "name prefix for all stack objects")
+	fset.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to the kubeconfig file")
+	fset.StringVar(&opts.kubecontext, "context", "", "kubeconfig context to use")
+	fset.Var(&opts.components, "component", "restrict to components (postgres,redis,clair)")
+	fset.DurationVar(&opts.timeout, "timeout", 30*time.Minute, "time to wait for readiness")
+	fset.Var(&opts.mirrors, "mirror", "rewrite images prefixed by FROM into TO (FROM=TO)")
+	fset.Var(&opts.digests, "digest", "pin image to digest (IMAGE=DIGEST)")
+	fset.BoolVar(&opts.pinDigests, "pin-digests", false, "fail if an image has no digest")
+	fset.IntVar(&opts.verbosity, "v", 0, "log verbosity, higher is more verbose")
+	fset.StringVar(&opts.metricsAddr, "metrics-addr", "", "serve prometheus metrics on ADDR")
+	if cmd == "apply" {
+		fset.StringVar(&opts.file, "f", "", "path to the stack manifest")
+	}
+	if cmd == "advertise" || cmd == "diff" {
+		fset.BoolVar(&opts.showSensitive, "show-sensitive", false, "print sensitive data as is")
+	}
+	return fset
+}
+
+// logger returns a logger honoring the verbosity set through flags.
+func (o *options) logger() logr.Logger {
+	return zap.New(zap.Level(zapcore.Level(-o.verbosity)))
+}
+
+// restConfig returns the configuration for accessing the cluster. Unless a kubeconfig or a
+// context is provided the default loading rules (including in cluster config) are used.
+func (o *options) restConfig() (*rest.Config, error) {
+	if o.kubeconfig == "" && o.kubecontext == "" {
+		return config.GetConfig()
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.kubecontext}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// rewriter returns the image rewriter configured through flags, nil if none is configured.
+func (o *options) rewriter() *mctrl.ImageRewriter {
+	if len(o.mirrors) == 0 && len(o.digests) == 0 && !o.pinDigests {
+		return nil
+	}
+
+	var rules []mctrl.ImageRule
+	for _, pair := range o.mirrors {
+		rules = append(rules, mctrl.ImageRule{From: pair[0], To: pair[1]})
+	}
+
+	digests := map[string]string{}
+	for _, pair := range o.digests {
+		digests[pair[0]] = pair[1]
+	}
+	return mctrl.NewImageRewriter(rules, digests, o.pinDigests)
+}
@@ -2,138 +2,177 @@ package main
 
 import (
 	"context"
-	"log"
-	"time"
-
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
-	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
-
-	"github.com/ricardomaraschini/freighter/ctrls/clair"
-	"github.com/ricardomaraschini/freighter/ctrls/postgres"
-	"github.com/ricardomaraschini/freighter/ctrls/redis"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/ricardomaraschini/freighter/ctrls/manifest"
 	"github.com/ricardomaraschini/freighter/infra/mctrl"
 )
 
-func main() {
-	ctx := context.Background()
+// The following are the exit codes returned by freighter, one per failure type.
+const (
+	exitOK = iota
+	exitFailure
+	exitUsage
+	exitCluster
+	exitNotReady
+	exitTimeout
+	exitConflict
+	exitDiffers
+)
 
-	cli, err := client.New(config.GetConfigOrDie(), client.Options{})
-	if err != nil {
-		log.Fatalf("error creating client: %s", err)
-	}
+// usage describes all commands and exit codes.
+const usage = `usage: freighter <command> [flags] [args]
+
+commands:
+  apply -f FILE        moves the components in the stack manifest to their overlays
+  deploy               moves components to the base overlay and waits until ready
+  scale-down           moves components to the scale-down overlay and waits until ready
+  status               prints the status of each component
+  advertise            prints the data advertised by each component, sensitive data is
+                       redacted unless -show-sensitive is set
+  render [overlay]     prints the objects for the overlay, nothing is applied
+  diff [overlay]       prints the differences between the cluster and the overlay, secret
+                       data is redacted unless -show-sensitive is set
+  images [overlay...]  prints all images the overlays would pull, all overlays provided by
+                       the components by default
+  destroy              deletes everything created by the components
+  operator             runs in operator mode reconciling ClairStack objects
+
+exit codes:
+  0 success, 1 failure, 2 usage error or invalid manifest, 3 unable to reach the cluster,
+  4 not ready, 5 timeout waiting for readiness, 6 field manager conflict, 7 differences found
+
+metrics are served on the address provided through -metrics-addr, in operator mode they are
+served on :8080 by default.
+
+run 'freighter <command> -h' for the list of flags.
+`
+
+// command is the signature of all commands.
+type command func(ctx context.Context, opts *options, args []string) error
+
+// commands maps command names into their implementation.
+var commands = map[string]command{
+	"deploy":     deploy,
+	"scale-down": scaleDown,
+	"status":     status,
+	"advertise":  advertise,
+	"apply":      apply,
+	"render":     render,
+	"diff":       diff,
+	"images":     images,
+	"destroy":    destroy,
+	"operator":   operator,
+}
 
-	cm := createCM(ctx, cli)
-
-	var ad mctrl.Ads
-
-	log.Printf("deploying clair postgres")
-	pgsql := postgres.New(
-		cli,
-		postgres.WithNamespace("rmarasch"),
-		postgres.WithNamePrefix("clair"),
-		postgres.WithOwnerReference(
-			metav1.OwnerReference{
-				APIVersion: "v1",
-				Name:       "testing",
-				Kind:       "ConfigMap",
-				UID:        cm.UID,
-			},
-		),
-	)
-	ad = apply(ctx, pgsql, mctrl.BaseOverlay, ad)
-
-	log.Printf("deploying redis")
-	rds := redis.New(
-		cli,
-		redis.WithNamespace("rmarasch"),
-		redis.WithNamePrefix("clair"),
-		redis.WithOwnerReference(
-			metav1.OwnerReference{
-				APIVersion: "v1",
-				Name:       "testing",
-				Kind:       "ConfigMap",
-				UID:        cm.UID,
-			},
-		),
-	)
-	ad = apply(ctx, rds, mctrl.BaseOverlay, ad)
-
-	log.Printf("deploying clair")
-	clr := clair.New(
-		cli,
-		clair.WithNamespace("rmarasch"),
-		clair.WithNamePrefix("clair"),
-		clair.WithOwnerReference(
-			metav1.OwnerReference{
-				APIVersion: "v1",
-				Name:       "testing",
-				Kind:       "ConfigMap",
-				UID:        cm.UID,
-			},
-		),
-	)
-	apply(ctx, clr, mctrl.BaseOverlay, ad)
-
-	log.Printf("scaling down clair")
-	apply(ctx, clr, mctrl.ScaleDownOverlay, ad)
-	log.Printf("scaling down redis")
-	apply(ctx, rds, mctrl.ScaleDownOverlay, ad)
-	log.Printf("scaling down pgsql")
-	apply(ctx, pgsql, mctrl.ScaleDownOverlay, ad)
+// usageError is returned when the command line is invalid.
+type usageError struct {
+	msg string
 }
 
-func apply(
-	ctx context.Context, mc mctrl.MicroController, overlay string, ad mctrl.Ads,
-) mctrl.Ads {
-	if err := mc.Apply(ctx, overlay, ad); err != nil {
-		log.Fatal(err)
-	}
+// Error returns the usage error message.
+func (u *usageError) Error() string {
+	return u.msg
+}
+
+// usageErrorf returns a formatted usageError.
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// clusterError is returned when we can't build a client for the cluster.
+type clusterError struct {
+	err error
+}
 
-	status, err := mc.Status(ctx)
-	if err != nil {
-		log.Fatal(err)
+// Error returns the wrapped error message.
+func (c *clusterError) Error() string {
+	return fmt.Sprintf("error accessing cluster: %s", c.err)
+}
+
+// Unwrap returns the wrapped error.
+func (c *clusterError) Unwrap() error {
+	return c.err
+}
+
+// errNotReady is returned by the status command when a component is not ready.
+var errNotReady = errors.New("not ready")
+
+// errDiffers is returned by the diff command when differences are found.
+var errDiffers = errors.New("differences found")
+
+// exitCode maps an error into its exit code.
+func exitCode(err error) int {
+	var uerr *usageError
+	var verr *manifest.ValidationError
+	var cerr *clusterError
+	var conflict *mctrl.ConflictError
+	var timeout *mctrl.WaitTimeoutError
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &uerr), errors.As(err, &verr):
+		return exitUsage
+	case errors.As(err, &cerr):
+		return exitCluster
+	case errors.Is(err, errNotReady):
+		return exitNotReady
+	case errors.As(err, &timeout):
+		return exitTimeout
+	case errors.As(err, &conflict):
+		return exitConflict
+	case errors.Is(err, errDiffers):
+		return exitDiffers
+	default:
+		return exitFailure
 	}
+}
 
-	for !status.Ready {
-		time.Sleep(time.Second)
-		status, err = mc.Status(ctx)
-		if err != nil {
-			log.Fatal(err)
-		}
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
 	}
+	flag.Parse()
 
-	ad, err = mc.Advertise(ctx)
-	if err != nil {
-		log.Fatal(err)
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
 	}
-	return ad
-}
 
-func createCM(ctx context.Context, cli client.Client) corev1.ConfigMap {
-	nsn := types.NamespacedName{
-		Namespace: "rmarasch",
-		Name:      "testing",
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(exitUsage)
 	}
-	var cm corev1.ConfigMap
-	if err := cli.Get(ctx, nsn, &cm); err == nil {
-		return cm
-	} else if !errors.IsNotFound(err) {
-		log.Fatal(err)
+
+	opts := &options{}
+	fset := flagSet(flag.Arg(0), opts)
+	if err := fset.Parse(flag.Args()[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitUsage)
 	}
 
-	cm = corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "testing",
-			Namespace: "rmarasch",
-		},
-		Data: map[string]string{},
+	logger := opts.logger()
+	ctrl.SetLogger(logger)
+	ctx := logr.NewContext(context.Background(), logger)
+
+	if opts.metricsAddr != "" && flag.Arg(0) != "operator" {
+		serveMetrics(logger, opts.metricsAddr)
 	}
-	if err := cli.Create(ctx, &cm); err != nil {
-		log.Fatal(err)
+
+	err := cmd(ctx, opts, fset.Args())
+	if err != nil && !errors.Is(err, errDiffers) {
+		logger.Error(err, "command failed", "command", flag.Arg(0))
 	}
-	return cm
+	os.Exit(exitCode(err))
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ricardomaraschini/freighter/ctrls/manifest"
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		code int
+	}{
+		{nil, exitOK},
+		{errors.New("boom"), exitFailure},
+		{usageErrorf("bad flag"), exitUsage},
+		{&manifest.ValidationError{Problems: []string{"no components"}}, exitUsage},
+		{&clusterError{err: errors.New("unreachable")}, exitCluster},
+		{fmt.Errorf("clair: %w", errNotReady), exitNotReady},
+		{&mctrl.WaitTimeoutError{Err: context.DeadlineExceeded}, exitTimeout},
+		{fmt.Errorf("applying: %w", &mctrl.ConflictError{}), exitConflict},
+		{errDiffers, exitDiffers},
+	} {
+		if code := exitCode(tt.err); code != tt.code {
+			t.Errorf("%v: expected exit code %d, got %d", tt.err, tt.code, code)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	opts := &options{}
+	fset := flagSet("images", opts)
+	if err := fset.Parse(
+		[]string{
+			"-component", "postgres, redis",
+			"-component", "clair",
+			"-mirror", "quay.io/=mirror.local/quay.io/",
+			"-digest", "mirror.local/quay.io/clair:4.3.0=sha256:aaa",
+			"base",
+		},
+	); err != nil {
+		t.Fatalf("error parsing flags: %s", err)
+	}
+
+	if expected := []string{"postgres", "redis", "clair"}; !reflect.DeepEqual(
+		[]string(opts.components), expected,
+	) {
+		t.Errorf("expected components %v, got %v", expected, opts.components)
+	}
+	if val := opts.mirrors.String(); val != "quay.io/=mirror.local/quay.io/" {
+		t.Errorf("unexpected mirrors %q", val)
+	}
+	if args := fset.Args(); !reflect.DeepEqual(args, []string{"base"}) {
+		t.Errorf("unexpected arguments %v", args)
+	}
+
+	img, err := opts.rewriter().Rewrite("quay.io/clair:4.3.0")
+	if err != nil {
+		t.Fatalf("error rewriting image: %s", err)
+	}
+	if img != "mirror.local/quay.io/clair@sha256:aaa" {
+		t.Errorf("unexpected rewritten image %q", img)
+	}
+
+	if err := flagSet("images", &options{}).Parse([]string{"-mirror", "quay.io"}); err == nil {
+		t.Error("expected error parsing mirror without target")
+	}
+
+	for _, cmd := range []string{"advertise", "diff"} {
+		opts := &options{}
+		if err := flagSet(cmd, opts).Parse([]string{"-show-sensitive"}); err != nil {
+			t.Errorf("error parsing %s flags: %s", cmd, err)
+		} else if !opts.showSensitive {
+			t.Errorf("-show-sensitive not set for %s", cmd)
+		}
+	}
+}
+
+func TestSelectComponents(t *testing.T) {
+	selected, err := selectComponents(&options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(selected, components) {
+		t.Errorf("expected all components selected, got %v", selected)
+	}
+
+	selected, err = selectComponents(&options{components: listFlag{"clair"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(selected, []string{"clair"}) {
+		t.Errorf("expected clair selected, got %v", selected)
+	}
+
+	_, err = selectComponents(&options{components: listFlag{"quay"}})
+	if exitCode(err) != exitUsage {
+		t.Errorf("expected usage error for unknown component, got %v", err)
+	}
+}
+
+func TestAppImages(t *testing.T) {
+	ctx := context.Background()
+	cli := mctrltest.NewHarness("default").Client
+	opts := &options{namespace: "default", prefix: "clair"}
+
+	for _, tt := range []struct {
+		selected []string
+		overlays []string
+		expected []string
+		missing  []string
+	}{
+		{
+			selected: []string{"redis"},
+			expected: []string{"redis"},
+			missing:  []string{"postgres", "clair"},
+		},
+		{
+			selected: []string{"postgres"},
+			expected: []string{"postgres"},
+			missing:  []string{"redis", "clair"},
+		},
+		{
+			selected: components,
+			overlays: []string{mctrl.BaseOverlay},
+			expected: []string{"postgres", "redis", "clair"},
+		},
+	} {
+		app, err := newApp(ctx, cli, opts, tt.selected)
+		if err != nil {
+			t.Fatalf("error building app: %s", err)
+		}
+
+		imgs, err := app.stack.ImagesSelected(ctx, app.selected, tt.overlays...)
+		if err != nil {
+			t.Fatalf("%v: error listing images: %s", tt.selected, err)
+		}
+
+		all := strings.Join(imgs, " ")
+		for _, name := range tt.expected {
+			if !strings.Contains(all, name) {
+				t.Errorf("%v: expected a %s image, got %v", tt.selected, name, imgs)
+			}
+		}
+		for _, name := range tt.missing {
+			if strings.Contains(all, name) {
+				t.Errorf("%v: unexpected %s image in %v", tt.selected, name, imgs)
+			}
+		}
+	}
+}
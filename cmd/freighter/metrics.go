@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// serveMetrics serves, in background, the metrics registered in the controller-runtime registry
+// under /metrics. Freighter metrics are registered there, see mctrl package. Failures to serve
+// are logged but do not interrupt the command.
+func serveMetrics(logger logr.Logger, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error(err, "error serving metrics", "addr", addr)
+		}
+	}()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/ctrls/clair"
+	"github.com/ricardomaraschini/freighter/ctrls/postgres"
+	"github.com/ricardomaraschini/freighter/ctrls/redis"
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// components holds the names of all stack components in dependency order.
+var components = []string{"postgres", "redis", "clair"}
+
+// app holds the stack together with its components indexed by name.
+type app struct {
+	stack    *mctrl.Stack
+	mctrls   map[string]mctrl.MicroController
+	selected []string
+	rewriter *mctrl.ImageRewriter
+}
+
+// selectComponents validates the components selected through flags against the known ones.
+// Returns all components if none has been selected.
+func selectComponents(opts *options) ([]string, error) {
+	known := map[string]bool{}
+	for _, name := range components {
+		known[name] = true
+	}
+
+	for _, name := range opts.components {
+		if !known[name] {
+			return nil, usageErrorf("unknown component %q", name)
+		}
+	}
+
+	if len(opts.components) == 0 {
+		return components, nil
+	}
+	return opts.components, nil
+}
+
+// newApp builds a stack composed by postgres, redis and clair according to the options. Only
+// the selected components are affected by the commands.
+func newApp(
+	ctx context.Context, cli client.Client, opts *options, selected []string,
+) (*app, error) {
+	pgsql := postgres.New(
+		cli,
+		postgres.WithNamespace(opts.namespace),
+		postgres.WithNamePrefix(opts.prefix),
+	)
+
+	rds := redis.New(
+		cli,
+		redis.WithNamespace(opts.namespace),
+		redis.WithNamePrefix(opts.prefix),
+	)
+
+	clr := clair.New(
+		cli,
+		clair.WithNamespace(opts.namespace),
+		clair.WithNamePrefix(opts.prefix),
+	)
+
+	stack := mctrl.NewStack()
+	stack.SetWaitOptions(waitOptions(ctx, opts))
+
+	if err := stack.Register("postgres", pgsql, nil, pgsql.Provides()); err != nil {
+		return nil, err
+	}
+
+	if err := stack.Register("redis", rds, nil, rds.Provides()); err != nil {
+		return nil, err
+	}
+
+	if err := stack.Register("clair", clr, clr.Requires(), clr.Provides()); err != nil {
+		return nil, err
+	}
+
+	rewriter := opts.rewriter()
+	if rewriter != nil {
+		if err := stack.AddOMutator(rewriter.Mutate); err != nil {
+			return nil, fmt.Errorf("error registering image rewriter: %w", err)
+		}
+	}
+
+	return &app{
+		stack: stack,
+		mctrls: map[string]mctrl.MicroController{
+			"postgres": pgsql,
+			"redis":    rds,
+			"clair":    clr,
+		},
+		selected: selected,
+		rewriter: rewriter,
+	}, nil
+}
+
+// waitOptions returns the options used when waiting for components to become ready. Progress
+// is logged through the logger in the context.
+func waitOptions(ctx context.Context, opts *options) mctrl.WaitOptions {
+	logger := logr.FromContextOrDiscard(ctx)
+	return mctrl.WaitOptions{
+		Timeout: opts.timeout,
+		Progress: func(st *mctrl.Status) {
+			logger.V(1).Info("waiting", mctrl.LogKeyReady, st.Ready, mctrl.LogKeyMessage, st.Message)
+		},
+	}
+}
+
+// overlays returns the overlays map used when moving the selected components to the provided
+// overlay, see mctrl.Stack.ApplyOverlays.
+func (a *app) overlays(overlay string) map[string]string {
+	overlays := map[string]string{}
+	for _, name := range a.selected {
+		overlays[name] = overlay
+	}
+	return overlays
+}
+
+// isSelected returns true if the component has been selected.
+func (a *app) isSelected(name string) bool {
+	for _, sel := range a.selected {
+		if sel == name {
+			return true
+		}
+	}
+	return false
+}
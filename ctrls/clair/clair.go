@@ -6,14 +6,10 @@ import (
 	"fmt"
 
 	"gopkg.in/yaml.v2"
-	appsv1 "k8s.io/api/apps/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ktypes "sigs.k8s.io/kustomize/api/types"
 
 	"github.com/ricardomaraschini/freighter/infra/mctrl"
-	"github.com/ricardomaraschini/freighter/infra/resource"
 )
 
 //go:embed kustomize/*
@@ -35,16 +31,20 @@ func New(cli client.Client, opts ...Option) *Clair {
 	for _, opt := range opts {
 		opt(cl)
 	}
+
+	cl.SetIdentity(cl.namespace, fmt.Sprintf("%s-clair", cl.namePrefix))
 	return cl
 }
 
-// Clair controls a clair deployment. Deploys clair server and keeps track of its status.
+// Clair controls a clair deployment. Deploys clair server and keeps track of its status (status
+// is computed by the embedded KustCtrl based on all objects it applied).
 type Clair struct {
 	*mctrl.KustCtrl
 
 	client     client.Client
 	namespace  string
 	namePrefix string
+	dbPrefix   string
 }
 
 // mutateKustomization makes sure we append a prefix to all created objects. It also attempts
@@ -52,7 +52,7 @@ type Clair struct {
 // valid clair configuration based in the received advertised data. Config is then placed in a
 // secret that is mounted in clair pods.
 func (c *Clair) mutateKustomization(
-	ctx context.Context, kust *ktypes.Kustomization, ads mctrl.Ads,
+	ctx context.Context, kust *ktypes.Kustomization, ads *mctrl.Ads,
 ) error {
 	config, err := c.buildClairConfig(ads)
 	if err != nil {
@@ -80,15 +80,54 @@ func (c *Clair) mutateKustomization(
 	return nil
 }
 
+// DBAdNames holds the names of the indexes clair needs from the postgres instance it binds to.
+var DBAdNames = []string{"dbhost", "dbport", "dbname", "dbrootuser", "dbrootpass"}
+
+// AdNames holds the names of all indexes advertised by this controller. Indexes are advertised
+// scoped, see Scope.
+var AdNames = []string{"clair-addr"}
+
+// Scope returns the scope under which this controller instance advertises its data.
+func (c *Clair) Scope() mctrl.Scope {
+	return mctrl.Scope{
+		Prefix:    c.namePrefix,
+		Component: "clair",
+	}
+}
+
+// Database returns the scope of the postgres instance this controller binds to. Unless set
+// through WithDatabase clair binds to the postgres instance using the same name prefix.
+func (c *Clair) Database() mctrl.Scope {
+	prefix := c.dbPrefix
+	if prefix == "" {
+		prefix = c.namePrefix
+	}
+	return mctrl.Scope{
+		Prefix:    prefix,
+		Component: "postgres",
+	}
+}
+
+// Requires returns the scoped indexes this controller instance needs during Apply.
+func (c *Clair) Requires() []string {
+	return c.Database().Keys(DBAdNames...)
+}
+
+// Provides returns the scoped indexes advertised by this controller instance.
+func (c *Clair) Provides() []string {
+	return c.Scope().Keys(AdNames...)
+}
+
 // buildClairConfig attempts to construct a valid clair config. Verifies all mandatory data is
-// present in received Ads. The following advertised info is mandatory: "dbhost", "dbport",
-// "dbname", "dbrootuser", "dbrootpass". TODO(rmarasch): For sake of simplicity leverages root
-// user and pass but this should be changed in the future.
-func (c *Clair) buildClairConfig(ads mctrl.Ads) (*Config, error) {
-	needed := []string{"dbhost", "dbport", "dbname", "dbrootuser", "dbrootpass"}
-	if err := ads.Contains(needed...); err != nil {
+// present in received Ads for the database clair binds to (see Database). The following
+// advertised info is mandatory: "dbhost", "dbport", "dbname", "dbrootuser", "dbrootpass".
+// TODO(rmarasch): For sake of simplicity leverages root user and pass but this should be changed
+// in the future.
+func (c *Clair) buildClairConfig(ads *mctrl.Ads) (*Config, error) {
+	if err := ads.Contains(c.Requires()...); err != nil {
 		return nil, fmt.Errorf("missing advertised data: %w", err)
 	}
+	dbads := ads.In(c.Database())
 
 	config, err := EmptyConfig()
 	if err != nil {
@@ -99,11 +138,11 @@ func (c *Clair) buildClairConfig(ads mctrl.Ads) (*Config, error) {
 	// info. Sets all agents to use the same database leveraging root user.
 	connstr := fmt.Sprintf(
 		"host=%s port=%s dbname=%s user=%s password=%s sslmode=disable",
-		ads.Get("dbhost"),
-		ads.Get("dbport"),
-		ads.Get("dbname"),
-		ads.Get("dbrootuser"),
-		ads.Get("dbrootpass"),
+		dbads.Get("dbhost"),
+		dbads.Get("dbport"),
+		dbads.Get("dbname"),
+		dbads.Get("dbrootuser"),
+		dbads.Get("dbrootpass"),
 	)
 	config.Indexer.ConnString = connstr
 	config.Matcher.ConnString = connstr
@@ -113,60 +152,24 @@ func (c *Clair) buildClairConfig(ads mctrl.Ads) (*Config, error) {
 }
 
 // Advertise returns data this component advertises. This component advertises only the clair
-// address. TODO(rmarasch): there is more info that needs to be advertised, not clear yet what.
-func (c *Clair) Advertise(ctx context.Context) (mctrl.Ads, error) {
-	var ads mctrl.Ads
-	if c.Overlay() == mctrl.ScaleDownOverlay {
-		return ads, nil
-	}
-	addr := fmt.Sprintf("%s-clair.%s", c.namePrefix, c.namespace)
-	ads.Put("clair-addr", addr)
-	return ads, nil
+// address, under this controller Scope. TODO(rmarasch): there is more info that needs to be
+// advertised, not clear yet what.
+func (c *Clair) Advertise(ctx context.Context) (*mctrl.Ads, error) {
+	return c.advertise(c.Overlay()), nil
 }
 
-// Status return the status for this component at the current overlay.
-func (c *Clair) Status(ctx context.Context) (*mctrl.Status, error) {
-	if c.Overlay() == mctrl.NotAppliedOverlay {
-		return nil, fmt.Errorf("no overlay applied to the controller")
-	}
-
-	nsn := types.NamespacedName{
-		Namespace: c.namespace,
-		Name:      fmt.Sprintf("%s-clair", c.namePrefix),
-	}
-
-	var dep appsv1.Deployment
-	if err := c.client.Get(ctx, nsn, &dep); err != nil {
-		return nil, fmt.Errorf("error getting deployment: %w", err)
-	}
-	spec := dep.Spec
-	stat := dep.Status
-
-	var replicas int32
-	if c.Overlay() != mctrl.ScaleDownOverlay && spec.Replicas != nil {
-		replicas = *spec.Replicas
-	}
-
-	var conds []metav1.Condition
-	for _, cond := range stat.Conditions {
-		mv1cond, err := resource.ToCondition(cond)
-		if err != nil {
-			return nil, fmt.Errorf("error converting condition: %w", err)
-		}
-		conds = append(conds, mv1cond)
-	}
+// RenderAds returns what this controller would advertise once moved to the provided overlay.
+func (c *Clair) RenderAds(ctx context.Context, overlay string) (*mctrl.Ads, error) {
+	return c.advertise(overlay), nil
+}
 
-	if stat.AvailableReplicas != replicas || stat.UpdatedReplicas != replicas {
-		return &mctrl.Status{
-			Ready:      false,
-			Message:    "deployment not fully available yet",
-			Conditions: conds,
-		}, nil
+// advertise returns the Ads for the provided overlay.
+func (c *Clair) advertise(overlay string) *mctrl.Ads {
+	ads := mctrl.NewAds()
+	if overlay == mctrl.ScaleDownOverlay {
+		return ads
 	}
-
-	return &mctrl.Status{
-		Ready:      true,
-		Message:    "deployment ready",
-		Conditions: conds,
-	}, nil
+	addr := fmt.Sprintf("%s-clair.%s", c.namePrefix, c.namespace)
+	ads.Put(c.Scope().Key("clair-addr"), addr)
+	return ads
 }
@@ -0,0 +1,70 @@
+package clair
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+)
+
+// overlays lists all overlays provided by the controller besides mctrl.BaseOverlay.
+var overlays = []string{mctrl.ScaleDownOverlay}
+
+// databaseAds returns the Ads a postgres instance using the provided name prefix advertises.
+func databaseAds(prefix string) *mctrl.Ads {
+	scope := mctrl.Scope{Prefix: prefix, Component: "postgres"}
+	ads := mctrl.NewAds()
+	ads.Put(scope.Key("dbhost"), prefix+"-database.test.svc")
+	ads.Put(scope.Key("dbport"), "5432")
+	ads.Put(scope.Key("dbname"), "database")
+	ads.Put(scope.Key("dbrootuser"), "postgres")
+	ads.PutSensitive(scope.Key("dbrootpass"), "rootpass")
+	return ads
+}
+
+func TestConformance(t *testing.T) {
+	harness := mctrltest.NewHarness("test")
+	suite := &mctrltest.Conformance{
+		Harness: harness,
+		New: func(cli client.Client) mctrl.MicroController {
+			return New(cli, WithNamespace(harness.Namespace), WithNamePrefix("test"))
+		},
+		Ads:      databaseAds("test"),
+		Provides: New(nil, WithNamePrefix("test")).Provides(),
+		Overlays: overlays,
+	}
+	suite.Run(t)
+}
+
+func TestGolden(t *testing.T) {
+	for _, overlay := range append([]string{mctrl.BaseOverlay}, overlays...) {
+		t.Run(overlay, func(t *testing.T) {
+			harness := mctrltest.NewHarness("test")
+			cl := New(harness.Client, WithNamespace(harness.Namespace), WithNamePrefix("test"))
+			mctrltest.AssertGoldenOverlay(
+				t, cl, overlay, databaseAds("test"), filepath.Join("testdata", overlay+".yaml"),
+			)
+		})
+	}
+}
+
+func TestGoldenWithDatabase(t *testing.T) {
+	harness := mctrltest.NewHarness("test")
+	cl := New(
+		harness.Client,
+		WithNamespace(harness.Namespace),
+		WithNamePrefix("test"),
+		WithDatabase("shared"),
+	)
+	mctrltest.AssertGoldenOverlay(
+		t, cl, mctrl.BaseOverlay, databaseAds("shared"), "testdata/with-database.yaml",
+	)
+
+	if _, err := cl.Render(context.Background(), mctrl.BaseOverlay, databaseAds("test")); err == nil {
+		t.Fatal("rendered without the ads of the bound database")
+	}
+}
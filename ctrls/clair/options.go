@@ -3,8 +3,22 @@ package clair
 import (
 	"context"
 
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// The following names refer to the objects as found in the embedded manifests.
+const (
+	imageName      = "goiaba.news:5000/quay/clair"
+	deploymentName = "clair"
+	containerName  = "clair"
 )
 
 // Option is a function capable of set an optional parameter.
@@ -45,3 +59,65 @@ func WithNamePrefix(prefix string) Option {
 		c.namePrefix = prefix
 	}
 }
+
+// WithDatabase binds clair to the postgres instance deployed with the provided name prefix. By
+// default clair binds to the postgres instance using the same name prefix as clair.
+func WithDatabase(prefix string) Option {
+	return func(c *Clair) {
+		c.dbPrefix = prefix
+	}
+}
+
+// WithKustOptions passes provided options to the underlying kustomize controller.
+func WithKustOptions(opts ...mctrl.KustOption) Option {
+	return func(c *Clair) {
+		for _, opt := range opts {
+			opt(c.KustCtrl)
+		}
+	}
+}
+
+// WithLogger sets the logger used by the controller, see mctrl.WithLogger.
+func WithLogger(logger logr.Logger) Option {
+	return func(c *Clair) {
+		mctrl.WithLogger(logger)(c.KustCtrl)
+	}
+}
+
+// WithEventRecorder makes the controller emit events on the provided owner, see
+// mctrl.WithEventRecorder.
+func WithEventRecorder(recorder record.EventRecorder, owner runtime.Object) Option {
+	return func(c *Clair) {
+		mctrl.WithEventRecorder(recorder, owner)(c.KustCtrl)
+	}
+}
+
+// WithImage replaces the clair image by the provided image reference, the reference may carry
+// a tag or a digest.
+func WithImage(image string) Option {
+	return func(c *Clair) {
+		mctrl.WithImage(imageName, image)(c.KustCtrl)
+	}
+}
+
+// WithReplicas sets the number of clair replicas for the base overlay.
+func WithReplicas(count int64) Option {
+	return func(c *Clair) {
+		mctrl.WithReplicas(deploymentName, count)(c.KustCtrl)
+	}
+}
+
+// WithResources sets the resource requests and limits for the clair container.
+func WithResources(res corev1.ResourceRequirements) Option {
+	return func(c *Clair) {
+		mctrl.WithResources("Deployment", deploymentName, containerName, res)(c.KustCtrl)
+	}
+}
+
+// WithPatches adds strategic merge or JSON6902 patches to the clair base kustomization. Patch
+// targets refer to objects by the names found in the manifests (without the name prefix).
+func WithPatches(patches ...ktypes.Patch) Option {
+	return func(c *Clair) {
+		mctrl.WithPatches(patches...)(c.KustCtrl)
+	}
+}
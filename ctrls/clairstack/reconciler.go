@@ -0,0 +1,340 @@
+package clairstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/api/v1alpha1"
+	"github.com/ricardomaraschini/freighter/ctrls/clair"
+	"github.com/ricardomaraschini/freighter/ctrls/postgres"
+	"github.com/ricardomaraschini/freighter/ctrls/redis"
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// RequeueInterval is the interval after which a ClairStack not yet ready is reconciled again.
+const RequeueInterval = 15 * time.Second
+
+// ReadyTimeout is how long a ClairStack may remain not ready before its Ready condition reports
+// a timeout. Reconciliation goes on after the timeout.
+const ReadyTimeout = 10 * time.Minute
+
+// NewReconciler returns a reconciler for ClairStack objects.
+func NewReconciler(cli client.Client) *Reconciler {
+	return &Reconciler{
+		cli:     cli,
+		timeout: ReadyTimeout,
+		stacks:  map[types.NamespacedName]*cachedStack{},
+	}
+}
+
+// Reconciler drives postgres, redis and clair MicroControllers toward the state declared in a
+// ClairStack. All created objects are owned by the ClairStack and the readiness of each component
+// is written back to the ClairStack status as conditions. Reconcile never blocks waiting for the
+// components, it applies what can be applied and requeues while they are not ready. Components
+// ready and up to date with the ClairStack are not applied again, see mctrl.UpToDater.
+type Reconciler struct {
+	cli      client.Client
+	timeout  time.Duration
+	recorder record.EventRecorder
+	mtx      sync.Mutex
+	stacks   map[types.NamespacedName]*cachedStack
+}
+
+// cachedStack is the stack built for a given ClairStack generation. Stacks are kept across
+// reconciles so their components keep their state, e.g. the last observed readiness, and are
+// only recovered from the cluster once.
+type cachedStack struct {
+	uid        types.UID
+	generation int64
+	stack      *mctrl.Stack
+	comps      []component
+}
+
+// component is a stack member together with the overlay the ClairStack requests for it.
+type component struct {
+	name      string
+	condition string
+	mctrl     mctrl.MicroController
+	overlay   string
+}
+
+// SetupWithManager registers the reconciler within the provided manager. Besides ClairStack
+// objects we also watch the deployments and services owned by them. Events emitted by the
+// components are recorded on their ClairStack.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.recorder = mgr.GetEventRecorderFor("freighter")
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.ClairStack{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Complete(r)
+}
+
+// Reconcile moves all stack components to the overlays declared in the ClairStack. The state of
+// each component is recovered from the cluster when a new ClairStack generation is seen. Components are applied in order up to the
+// first one not yet ready, their consumers are only applied on a later reconcile. While
+// components are not ready the ClairStack is requeued.
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var cs v1alpha1.ClairStack
+	if err := r.cli.Get(ctx, req.NamespacedName, &cs); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.forget(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("error reading clair stack: %w", err)
+	}
+
+	stack, comps, err := r.stackFor(ctx, &cs)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	overlays := map[string]string{}
+	for _, comp := range comps {
+		overlays[comp.name] = comp.overlay
+	}
+
+	// a component not yet ready is not a failure, it is reported through the conditions and
+	// the clair stack is requeued.
+	_, applyErr := stack.ApplyOverlays(ctx, overlays)
+	var notready *mctrl.NotReadyError
+	if errors.As(applyErr, &notready) {
+		applyErr = nil
+	}
+
+	ready, err := r.updateStatus(ctx, &cs, comps, applyErr)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if applyErr != nil {
+		return ctrl.Result{}, applyErr
+	}
+	if !ready {
+		return ctrl.Result{RequeueAfter: RequeueInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// stackFor returns the stack for the provided ClairStack and its components. The stack is built,
+// and its state recovered from the cluster, the first time the ClairStack generation is seen.
+func (r *Reconciler) stackFor(
+	ctx context.Context, cs *v1alpha1.ClairStack,
+) (*mctrl.Stack, []component, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	nsn := types.NamespacedName{Namespace: cs.Namespace, Name: cs.Name}
+	if cached, ok := r.stacks[nsn]; ok {
+		if cached.uid == cs.UID && cached.generation == cs.Generation {
+			return cached.stack, cached.comps, nil
+		}
+	}
+
+	stack, comps, err := r.stack(cs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := stack.Recover(ctx); err != nil {
+		return nil, nil, fmt.Errorf("error recovering stack: %w", err)
+	}
+
+	r.stacks[nsn] = &cachedStack{
+		uid:        cs.UID,
+		generation: cs.Generation,
+		stack:      stack,
+		comps:      comps,
+	}
+	return stack, comps, nil
+}
+
+// forget drops the stack cached for the ClairStack, if any.
+func (r *Reconciler) forget(nsn types.NamespacedName) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	delete(r.stacks, nsn)
+}
+
+// stack builds a Stack for the provided ClairStack. Returns the stack and its components.
+func (r *Reconciler) stack(cs *v1alpha1.ClairStack) (*mctrl.Stack, []component, error) {
+	isctrl := true
+	oref := metav1.OwnerReference{
+		APIVersion:         v1alpha1.GroupVersion.String(),
+		Kind:               "ClairStack",
+		Name:               cs.Name,
+		UID:                cs.UID,
+		Controller:         &isctrl,
+		BlockOwnerDeletion: &isctrl,
+	}
+
+	prefix := func(spec v1alpha1.ComponentSpec) string {
+		if spec.NamePrefix == "" {
+			return cs.Name
+		}
+		return spec.NamePrefix
+	}
+
+	pgsql := postgres.New(
+		r.cli,
+		postgres.WithNamespace(cs.Namespace),
+		postgres.WithNamePrefix(prefix(cs.Spec.Postgres)),
+		postgres.WithOwnerReference(oref),
+		postgres.WithEventRecorder(r.recorder, cs),
+	)
+
+	rds := redis.New(
+		r.cli,
+		redis.WithNamespace(cs.Namespace),
+		redis.WithNamePrefix(prefix(cs.Spec.Redis)),
+		redis.WithOwnerReference(oref),
+		redis.WithEventRecorder(r.recorder, cs),
+	)
+
+	clr := clair.New(
+		r.cli,
+		clair.WithNamespace(cs.Namespace),
+		clair.WithNamePrefix(prefix(cs.Spec.Clair)),
+		clair.WithDatabase(prefix(cs.Spec.Postgres)),
+		clair.WithOwnerReference(oref),
+		clair.WithEventRecorder(r.recorder, cs),
+	)
+
+	stack := mctrl.NewStack()
+	stack.SetWaitOptions(mctrl.WaitOptions{NoWait: true})
+	stack.SetSkipUpToDate(true)
+
+	if err := stack.Register("postgres", pgsql, nil, pgsql.Provides()); err != nil {
+		return nil, nil, err
+	}
+
+	if err := stack.Register("redis", rds, nil, rds.Provides()); err != nil {
+		return nil, nil, err
+	}
+
+	if err := stack.Register("clair", clr, clr.Requires(), clr.Provides()); err != nil {
+		return nil, nil, err
+	}
+
+	comps := []component{
+		{"postgres", "PostgresReady", pgsql, cs.Spec.Postgres.Overlay},
+		{"redis", "RedisReady", rds, cs.Spec.Redis.Overlay},
+		{"clair", "ClairReady", clr, cs.Spec.Clair.Overlay},
+	}
+	for i := range comps {
+		if comps[i].overlay == "" {
+			comps[i].overlay = mctrl.BaseOverlay
+		}
+	}
+	return stack, comps, nil
+}
+
+// updateStatus writes the status of each component into the ClairStack status. A condition is
+// set for each component (e.g. PostgresReady) and an aggregated Ready condition is set as well.
+// If applying the stack failed the Ready condition carries the error, if the stack has not been
+// ready for longer than the timeout it carries a WaitTimeoutError. Returns whether all components
+// are ready.
+func (r *Reconciler) updateStatus(
+	ctx context.Context, cs *v1alpha1.ClairStack, comps []component, applyErr error,
+) (bool, error) {
+	ready := true
+	var comstatus []v1alpha1.ComponentStatus
+	var notready []string
+	var laststatus *mctrl.Status
+	for _, comp := range comps {
+		cstatus := v1alpha1.ComponentStatus{
+			Name:    comp.name,
+			Overlay: comp.mctrl.Overlay(),
+		}
+
+		status, err := comp.mctrl.Status(ctx)
+		if err != nil {
+			cstatus.Message = err.Error()
+		} else {
+			cstatus.Ready = status.Ready
+			cstatus.Message = status.Message
+		}
+
+		// a component is only considered ready once it reached the requested overlay.
+		if comp.mctrl.Overlay() != comp.overlay {
+			cstatus.Ready = false
+		}
+
+		if !cstatus.Ready && laststatus == nil {
+			laststatus = status
+		}
+
+		cond := metav1.Condition{
+			Type:               comp.condition,
+			Status:             metav1.ConditionTrue,
+			Reason:             "ComponentReady",
+			Message:            cstatus.Message,
+			ObservedGeneration: cs.Generation,
+		}
+		if !cstatus.Ready {
+			cond.Status = metav1.ConditionFalse
+			cond.Reason = "ComponentNotReady"
+			ready = false
+			notready = append(notready, comp.name)
+		}
+		meta.SetStatusCondition(&cs.Status.Conditions, cond)
+		comstatus = append(comstatus, cstatus)
+	}
+
+	cond := metav1.Condition{
+		Type:               mctrl.ReadyCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             "StackReady",
+		Message:            "all components ready",
+		ObservedGeneration: cs.Generation,
+	}
+	if applyErr != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = "ApplyFailed"
+		cond.Message = applyErr.Error()
+	} else if !ready {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = "StackNotReady"
+		cond.Message = fmt.Sprintf("components not ready: %s", strings.Join(notready, ", "))
+		if terr := r.readyTimeout(cs, laststatus); terr != nil {
+			cond.Reason = "WaitTimeout"
+			cond.Message = terr.Error()
+		}
+	}
+	meta.SetStatusCondition(&cs.Status.Conditions, cond)
+
+	cs.Status.Components = comstatus
+	cs.Status.ObservedGeneration = cs.Generation
+	if err := r.cli.Status().Update(ctx, cs); err != nil {
+		return false, fmt.Errorf("error updating clair stack status: %w", err)
+	}
+	return ready && applyErr == nil, nil
+}
+
+// readyTimeout returns a WaitTimeoutError if the ClairStack Ready condition has been false for
+// longer than the timeout, nil otherwise. The error carries the status of the first component
+// not yet ready.
+func (r *Reconciler) readyTimeout(cs *v1alpha1.ClairStack, last *mctrl.Status) error {
+	prev := meta.FindStatusCondition(cs.Status.Conditions, mctrl.ReadyCondition)
+	if prev == nil || prev.Status != metav1.ConditionFalse {
+		return nil
+	}
+	if time.Since(prev.LastTransitionTime.Time) < r.timeout {
+		return nil
+	}
+	return &mctrl.WaitTimeoutError{Status: last, Err: context.DeadlineExceeded}
+}
@@ -0,0 +1,273 @@
+package clairstack
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/api/v1alpha1"
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+)
+
+// key is the key of the ClairStack used in tests.
+var key = types.NamespacedName{Namespace: "test", Name: "quay"}
+
+// newHarness returns a harness holding a ClairStack and a reconciler acting on it.
+func newHarness(spec v1alpha1.ClairStackSpec) (*mctrltest.Harness, *Reconciler) {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+
+	cs := &v1alpha1.ClairStack{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  key.Namespace,
+			Name:       key.Name,
+			UID:        "quay-uid",
+			Generation: 1,
+		},
+		Spec: spec,
+	}
+
+	harness := &mctrltest.Harness{
+		Client:    mctrltest.NewClient(scheme, cs),
+		Namespace: key.Namespace,
+	}
+
+	rec := NewReconciler(harness.Client)
+	rec.recorder = record.NewFakeRecorder(1024)
+	return harness, rec
+}
+
+// reconcile reconciles the ClairStack, settling the workloads after each pass, until it is no
+// longer requeued. Returns the ClairStack as left by the last pass.
+func reconcile(
+	ctx context.Context, t *testing.T, h *mctrltest.Harness, rec *Reconciler,
+) *v1alpha1.ClairStack {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		res, err := rec.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		if err != nil {
+			t.Fatalf("error reconciling: %s", err)
+		}
+
+		if res.RequeueAfter == 0 {
+			return clairStack(ctx, t, h.Client)
+		}
+
+		if res.RequeueAfter != RequeueInterval {
+			t.Fatalf("unexpected requeue interval %s", res.RequeueAfter)
+		}
+
+		if err := h.Settle(ctx); err != nil {
+			t.Fatalf("error settling: %s", err)
+		}
+	}
+	t.Fatal("clair stack did not become ready")
+	return nil
+}
+
+// clairStack reads the ClairStack from the cluster.
+func clairStack(ctx context.Context, t *testing.T, cli client.Client) *v1alpha1.ClairStack {
+	t.Helper()
+	var cs v1alpha1.ClairStack
+	if err := cli.Get(ctx, key, &cs); err != nil {
+		t.Fatalf("error reading clair stack: %s", err)
+	}
+	return &cs
+}
+
+// assertCondition checks the status and reason of a ClairStack condition.
+func assertCondition(
+	t *testing.T, cs *v1alpha1.ClairStack, ctype string, status metav1.ConditionStatus, reason string,
+) {
+	t.Helper()
+	cond := meta.FindStatusCondition(cs.Status.Conditions, ctype)
+	if cond == nil {
+		t.Fatalf("condition %s not found", ctype)
+	}
+	if cond.Status != status || cond.Reason != reason {
+		t.Errorf("expected %s %s/%s, got %s/%s", ctype, status, reason, cond.Status, cond.Reason)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	harness, rec := newHarness(v1alpha1.ClairStackSpec{})
+
+	res, err := rec.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("error reconciling: %s", err)
+	}
+	if res.RequeueAfter != RequeueInterval {
+		t.Errorf("expected requeue while not ready, got %+v", res)
+	}
+
+	cs := clairStack(ctx, t, harness.Client)
+	assertCondition(t, cs, mctrl.ReadyCondition, metav1.ConditionFalse, "StackNotReady")
+	assertCondition(t, cs, "PostgresReady", metav1.ConditionFalse, "ComponentNotReady")
+
+	cs = reconcile(ctx, t, harness, rec)
+	assertCondition(t, cs, mctrl.ReadyCondition, metav1.ConditionTrue, "StackReady")
+	for _, ctype := range []string{"PostgresReady", "RedisReady", "ClairReady"} {
+		assertCondition(t, cs, ctype, metav1.ConditionTrue, "ComponentReady")
+	}
+	if cs.Status.ObservedGeneration != cs.Generation {
+		t.Errorf("observed generation %d, expected %d", cs.Status.ObservedGeneration, cs.Generation)
+	}
+
+	if len(cs.Status.Components) != 3 {
+		t.Fatalf("expected three components, got %+v", cs.Status.Components)
+	}
+	for _, comp := range cs.Status.Components {
+		if !comp.Ready || comp.Overlay != mctrl.BaseOverlay {
+			t.Errorf("unexpected component status %+v", comp)
+		}
+	}
+
+	var deps appsv1.DeploymentList
+	if err := harness.Client.List(ctx, &deps, client.InNamespace(key.Namespace)); err != nil {
+		t.Fatalf("error listing deployments: %s", err)
+	}
+	if len(deps.Items) == 0 {
+		t.Fatal("no deployment created")
+	}
+	for _, dep := range deps.Items {
+		owner := metav1.GetControllerOf(&dep)
+		if owner == nil || owner.Kind != "ClairStack" || owner.UID != cs.UID {
+			t.Errorf("deployment %s not owned by the clair stack: %+v", dep.Name, owner)
+		}
+	}
+}
+
+// drain returns the events recorded so far.
+func drain(rec *Reconciler) []string {
+	var events []string
+	recorder := rec.recorder.(*record.FakeRecorder)
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestReconcileUpToDate(t *testing.T) {
+	ctx := context.Background()
+	harness, rec := newHarness(v1alpha1.ClairStackSpec{})
+	reconcile(ctx, t, harness, rec)
+	if events := drain(rec); len(events) == 0 {
+		t.Fatal("no events recorded while applying")
+	}
+
+	var before appsv1.Deployment
+	nsn := types.NamespacedName{Namespace: key.Namespace, Name: "quay-clair"}
+	if err := harness.Client.Get(ctx, nsn, &before); err != nil {
+		t.Fatalf("error reading clair deployment: %s", err)
+	}
+
+	// nothing changed, nothing is applied.
+	cs := reconcile(ctx, t, harness, rec)
+	assertCondition(t, cs, mctrl.ReadyCondition, metav1.ConditionTrue, "StackReady")
+	if events := drain(rec); len(events) > 0 {
+		t.Errorf("events recorded while up to date: %v", events)
+	}
+
+	var after appsv1.Deployment
+	if err := harness.Client.Get(ctx, nsn, &after); err != nil {
+		t.Fatalf("error reading clair deployment: %s", err)
+	}
+	if before.ResourceVersion != after.ResourceVersion {
+		t.Errorf("clair deployment patched while up to date")
+	}
+
+	// a component no longer ready is applied again.
+	if err := harness.Client.Delete(ctx, &after); err != nil {
+		t.Fatalf("error deleting clair deployment: %s", err)
+	}
+
+	cs = reconcile(ctx, t, harness, rec)
+	assertCondition(t, cs, mctrl.ReadyCondition, metav1.ConditionTrue, "StackReady")
+	if events := drain(rec); len(events) == 0 {
+		t.Error("no events recorded while applying a component no longer ready")
+	}
+	if err := harness.Client.Get(ctx, nsn, &after); err != nil {
+		t.Errorf("clair deployment not applied again: %s", err)
+	}
+}
+
+func TestReconcileOverlay(t *testing.T) {
+	ctx := context.Background()
+	harness, rec := newHarness(v1alpha1.ClairStackSpec{})
+	cs := reconcile(ctx, t, harness, rec)
+
+	cs.Spec.Clair.Overlay = mctrl.ScaleDownOverlay
+	cs.Generation++
+	if err := harness.Client.Update(ctx, cs); err != nil {
+		t.Fatalf("error updating clair stack: %s", err)
+	}
+
+	cs = reconcile(ctx, t, harness, rec)
+	assertCondition(t, cs, mctrl.ReadyCondition, metav1.ConditionTrue, "StackReady")
+	for _, comp := range cs.Status.Components {
+		expected := mctrl.BaseOverlay
+		if comp.Name == "clair" {
+			expected = mctrl.ScaleDownOverlay
+		}
+		if comp.Overlay != expected {
+			t.Errorf("expected %s at %s, got %s", comp.Name, expected, comp.Overlay)
+		}
+	}
+
+	var dep appsv1.Deployment
+	if err := harness.Client.Get(
+		ctx, types.NamespacedName{Namespace: key.Namespace, Name: "quay-clair"}, &dep,
+	); err != nil {
+		t.Fatalf("error reading clair deployment: %s", err)
+	}
+	if dep.Spec.Replicas == nil || *dep.Spec.Replicas != 0 {
+		t.Errorf("clair deployment not scaled down: %v", dep.Spec.Replicas)
+	}
+}
+
+func TestReconcileTimeout(t *testing.T) {
+	ctx := context.Background()
+	harness, rec := newHarness(v1alpha1.ClairStackSpec{})
+	rec.timeout = 0
+
+	for i := 0; i < 2; i++ {
+		if _, err := rec.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("error reconciling: %s", err)
+		}
+	}
+
+	cs := clairStack(ctx, t, harness.Client)
+	assertCondition(t, cs, mctrl.ReadyCondition, metav1.ConditionFalse, "WaitTimeout")
+}
+
+func TestReconcileNotFound(t *testing.T) {
+	_, rec := newHarness(v1alpha1.ClairStackSpec{})
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{Namespace: key.Namespace, Name: "missing"},
+	}
+
+	res, err := rec.Reconcile(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res != (ctrl.Result{}) {
+		t.Errorf("unexpected requeue %+v", res)
+	}
+}
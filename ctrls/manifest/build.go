@@ -0,0 +1,154 @@
+package manifest
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/ctrls/clair"
+	"github.com/ricardomaraschini/freighter/ctrls/postgres"
+	"github.com/ricardomaraschini/freighter/ctrls/redis"
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// Build creates the MicroControllers for all components and registers them in a new Stack,
+// components are registered with the names used in the manifest. Returns the stack and the
+// overlays each component should be moved to, see mctrl.Stack.ApplyOverlays.
+func (m *Manifest) Build(cli client.Client) (*mctrl.Stack, map[string]string, error) {
+	stack := mctrl.NewStack()
+	overlays := map[string]string{}
+
+	for _, comp := range m.Components {
+		var err error
+		switch comp.Type {
+		case TypePostgres:
+			pg := postgres.New(cli, m.postgresOptions(comp)...)
+			err = stack.Register(comp.Name, pg, nil, pg.Provides())
+		case TypeRedis:
+			rds := redis.New(cli, m.redisOptions(comp)...)
+			err = stack.Register(comp.Name, rds, nil, rds.Provides())
+		case TypeClair:
+			clr := clair.New(cli, m.clairOptions(comp)...)
+			err = stack.Register(comp.Name, clr, clr.Requires(), clr.Provides())
+		default:
+			err = fmt.Errorf("unknown component type %q", comp.Type)
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("error building %q: %w", comp.Name, err)
+		}
+		overlays[comp.Name] = comp.Overlay
+	}
+	return stack, overlays, nil
+}
+
+// kustOptions returns the KustCtrl options for the component.
+func kustOptions(comp Component) []mctrl.KustOption {
+	var opts []mctrl.KustOption
+	if comp.Options.LayerDir != "" {
+		opts = append(opts, mctrl.WithLayerDir(comp.Options.LayerDir))
+	}
+	if comp.Options.FieldManager != "" {
+		opts = append(opts, mctrl.WithFieldManager(comp.Options.FieldManager))
+	}
+	if comp.Options.ForceOwnership {
+		opts = append(opts, mctrl.WithForceOwnership())
+	}
+	if comp.Options.Transactional {
+		opts = append(opts, mctrl.WithTransaction())
+	}
+	if comp.Options.Prune != nil && !*comp.Options.Prune {
+		opts = append(opts, mctrl.WithoutPrune())
+	}
+	return opts
+}
+
+// postgresOptions returns the options for a postgres component.
+func (m *Manifest) postgresOptions(comp Component) []postgres.Option {
+	opts := []postgres.Option{
+		postgres.WithNamespace(comp.Namespace),
+		postgres.WithNamePrefix(comp.NamePrefix),
+		postgres.WithKustOptions(kustOptions(comp)...),
+	}
+	if comp.Options.Image != "" {
+		opts = append(opts, postgres.WithImage(comp.Options.Image))
+	}
+	if comp.Options.Replicas != nil {
+		opts = append(opts, postgres.WithReplicas(*comp.Options.Replicas))
+	}
+	if comp.Options.Resources != nil {
+		opts = append(opts, postgres.WithResources(*comp.Options.Resources))
+	}
+	if len(comp.Options.Patches) > 0 {
+		opts = append(opts, postgres.WithPatches(comp.Options.Patches...))
+	}
+	if bkp := comp.Options.Backup; bkp != nil {
+		opts = append(
+			opts,
+			postgres.WithBackupSchedule(bkp.Schedule),
+			postgres.WithBackupRetention(bkp.Retention),
+		)
+		if bkp.S3 != nil {
+			opts = append(
+				opts,
+				postgres.WithBackupS3(
+					postgres.S3Target{
+						Endpoint:   bkp.S3.Endpoint,
+						Bucket:     bkp.S3.Bucket,
+						Prefix:     bkp.S3.Prefix,
+						SecretName: bkp.S3.SecretName,
+					},
+				),
+			)
+		}
+	}
+	return opts
+}
+
+// redisOptions returns the options for a redis component.
+func (m *Manifest) redisOptions(comp Component) []redis.Option {
+	opts := []redis.Option{
+		redis.WithNamespace(comp.Namespace),
+		redis.WithNamePrefix(comp.NamePrefix),
+		redis.WithKustOptions(kustOptions(comp)...),
+	}
+	if comp.Options.Image != "" {
+		opts = append(opts, redis.WithImage(comp.Options.Image))
+	}
+	if comp.Options.Replicas != nil {
+		opts = append(opts, redis.WithReplicas(*comp.Options.Replicas))
+	}
+	if comp.Options.Resources != nil {
+		opts = append(opts, redis.WithResources(*comp.Options.Resources))
+	}
+	if len(comp.Options.Patches) > 0 {
+		opts = append(opts, redis.WithPatches(comp.Options.Patches...))
+	}
+	return opts
+}
+
+// clairOptions returns the options for a clair component. The database binding, if present,
+// binds clair to the postgres component it refers to.
+func (m *Manifest) clairOptions(comp Component) []clair.Option {
+	opts := []clair.Option{
+		clair.WithNamespace(comp.Namespace),
+		clair.WithNamePrefix(comp.NamePrefix),
+		clair.WithKustOptions(kustOptions(comp)...),
+	}
+	if target, ok := comp.Bindings["database"]; ok {
+		opts = append(opts, clair.WithDatabase(m.Component(target).NamePrefix))
+	}
+	if comp.Options.Image != "" {
+		opts = append(opts, clair.WithImage(comp.Options.Image))
+	}
+	if comp.Options.Replicas != nil {
+		opts = append(opts, clair.WithReplicas(*comp.Options.Replicas))
+	}
+	if comp.Options.Resources != nil {
+		opts = append(opts, clair.WithResources(*comp.Options.Resources))
+	}
+	if len(comp.Options.Patches) > 0 {
+		opts = append(opts, clair.WithPatches(comp.Options.Patches...))
+	}
+	return opts
+}
@@ -0,0 +1,301 @@
+// Package manifest loads stacks described in YAML files. A manifest lists the components of a
+// stack, how each one of them is configured, the overlay each one should be moved to and how
+// they bind to each other through their Ads.
+//
+//	apiVersion: freighter.io/v1alpha1
+//	kind: Stack
+//	components:
+//	  - name: database
+//	    type: postgres
+//	    namespace: quay
+//	    namePrefix: clair
+//	    overlay: backup
+//	    options:
+//	      backup:
+//	        schedule: "0 3 * * *"
+//	        retention: 14
+//	  - name: clair
+//	    type: clair
+//	    namespace: quay
+//	    namePrefix: clair
+//	    overlay: base
+//	    options:
+//	      image: quay.io/projectquay/clair:4.3.0
+//	      replicas: 2
+//	    bindings:
+//	      database: database
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// The following values identify a stack manifest.
+const (
+	APIVersion = "freighter.io/v1alpha1"
+	Kind       = "Stack"
+)
+
+// The following are the supported component types.
+const (
+	TypePostgres = "postgres"
+	TypeRedis    = "redis"
+	TypeClair    = "clair"
+)
+
+// bindings maps each component type into the bindings it accepts and the component type each
+// binding must refer to.
+var bindings = map[string]map[string]string{
+	TypePostgres: {},
+	TypeRedis:    {},
+	TypeClair: {
+		"database": TypePostgres,
+	},
+}
+
+// Manifest describes a stack.
+type Manifest struct {
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Components []Component `json:"components"`
+}
+
+// Component describes a single stack component. Name identifies the component within the stack
+// and is used as reference in bindings. Namespace defaults to "default", NamePrefix defaults to
+// the component name and Overlay defaults to the base overlay. Bindings map the Ads a component
+// consumes into the name of the component providing them, e.g. clair binds its "database" to a
+// postgres component. Without a binding clair binds to the postgres component sharing its
+// NamePrefix. Components advertise their Ads scoped by NamePrefix and type only (see
+// mctrl.Scope) so two components of the same type can't share a NamePrefix, even if they live
+// in different namespaces.
+type Component struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Namespace  string            `json:"namespace,omitempty"`
+	NamePrefix string            `json:"namePrefix,omitempty"`
+	Overlay    string            `json:"overlay,omitempty"`
+	Options    Options           `json:"options,omitempty"`
+	Bindings   map[string]string `json:"bindings,omitempty"`
+}
+
+// Options holds the optional settings for a component.
+type Options struct {
+	Image          string                       `json:"image,omitempty"`
+	Replicas       *int64                       `json:"replicas,omitempty"`
+	Resources      *corev1.ResourceRequirements `json:"resources,omitempty"`
+	Patches        []ktypes.Patch               `json:"patches,omitempty"`
+	LayerDir       string                       `json:"layerDir,omitempty"`
+	FieldManager   string                       `json:"fieldManager,omitempty"`
+	ForceOwnership bool                         `json:"forceOwnership,omitempty"`
+	Transactional  bool                         `json:"transactional,omitempty"`
+	Prune          *bool                        `json:"prune,omitempty"`
+	Backup         *BackupOptions               `json:"backup,omitempty"`
+}
+
+// BackupOptions holds the backup settings for postgres components, backups are only taken at
+// the backup overlay. Dumps are kept in a claim unless an S3 target is provided.
+type BackupOptions struct {
+	Schedule  string     `json:"schedule,omitempty"`
+	Retention int        `json:"retention,omitempty"`
+	S3        *S3Options `json:"s3,omitempty"`
+}
+
+// S3Options points to an S3 compatible endpoint backups are sent to. The secret must contain
+// the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY keys.
+type S3Options struct {
+	Endpoint   string `json:"endpoint"`
+	Bucket     string `json:"bucket"`
+	Prefix     string `json:"prefix,omitempty"`
+	SecretName string `json:"secretName"`
+}
+
+// ValidationError is returned when a manifest does not pass validation. It holds one entry per
+// problem found.
+type ValidationError struct {
+	Problems []string
+}
+
+// Error returns all problems found, semicolon separated.
+func (v *ValidationError) Error() string {
+	return fmt.Sprintf("invalid stack manifest: %s", strings.Join(v.Problems, "; "))
+}
+
+// LoadFile reads and parses the manifest in the provided file, see Parse.
+func LoadFile(path string) (*Manifest, error) {
+	dt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+	return Parse(dt)
+}
+
+// Parse parses and validates a manifest. Unknown fields are rejected. Defaults are set on the
+// returned manifest.
+func Parse(dt []byte) (*Manifest, error) {
+	var man Manifest
+	if err := yaml.UnmarshalStrict(dt, &man); err != nil {
+		return nil, &ValidationError{Problems: []string{err.Error()}}
+	}
+
+	man.setDefaults()
+	if err := man.Validate(); err != nil {
+		return nil, err
+	}
+	return &man, nil
+}
+
+// setDefaults fills in the default values for all optional fields.
+func (m *Manifest) setDefaults() {
+	for i := range m.Components {
+		comp := &m.Components[i]
+		if comp.Namespace == "" {
+			comp.Namespace = "default"
+		}
+		if comp.NamePrefix == "" {
+			comp.NamePrefix = comp.Name
+		}
+		if comp.Overlay == "" {
+			comp.Overlay = mctrl.BaseOverlay
+		}
+	}
+}
+
+// Validate checks the manifest for errors. Returns a ValidationError listing all problems.
+func (m *Manifest) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if m.APIVersion != APIVersion {
+		addf("apiVersion must be %q", APIVersion)
+	}
+	if m.Kind != Kind {
+		addf("kind must be %q", Kind)
+	}
+	if len(m.Components) == 0 {
+		addf("no components")
+	}
+
+	types := map[string]string{}
+	instances := map[string]string{}
+	for i, comp := range m.Components {
+		field := fmt.Sprintf("components[%d]", i)
+		if comp.Name == "" {
+			addf("%s.name is required", field)
+		} else if _, dup := types[comp.Name]; dup {
+			addf("%s.name %q is duplicated", field, comp.Name)
+		}
+		types[comp.Name] = comp.Type
+
+		if _, ok := bindings[comp.Type]; !ok {
+			addf("%s.type %q is not one of postgres, redis or clair", field, comp.Type)
+		}
+
+		for _, msg := range validation.IsDNS1123Label(comp.Namespace) {
+			addf("%s.namespace: %s", field, msg)
+		}
+		for _, msg := range validation.IsDNS1123Label(comp.NamePrefix) {
+			addf("%s.namePrefix: %s", field, msg)
+		}
+
+		// two instances of the same type can't share a prefix as their ads would collide, ads
+		// are not scoped by namespace.
+		instance := fmt.Sprintf("%s/%s", comp.NamePrefix, comp.Type)
+		if prev, ok := instances[instance]; ok {
+			addf("%s collides with %q (same type and prefix)", field, prev)
+		}
+		instances[instance] = comp.Name
+
+		if comp.Options.Replicas != nil && *comp.Options.Replicas < 0 {
+			addf("%s.options.replicas must not be negative", field)
+		}
+		if comp.Options.Backup != nil {
+			for _, msg := range validateBackup(comp) {
+				addf("%s.options.backup%s", field, msg)
+			}
+		}
+	}
+
+	for i, comp := range m.Components {
+		field := fmt.Sprintf("components[%d].bindings", i)
+		accepted := bindings[comp.Type]
+		for binding, target := range comp.Bindings {
+			want, ok := accepted[binding]
+			if !ok {
+				addf("%s: %s does not accept binding %q", field, comp.Type, binding)
+				continue
+			}
+
+			got, ok := types[target]
+			if !ok {
+				addf("%s.%s: component %q not found", field, binding, target)
+			} else if got != want {
+				addf("%s.%s: component %q is not a %s", field, binding, target, want)
+			}
+		}
+
+		// without a binding clair binds to the postgres sharing its prefix.
+		if _, ok := comp.Bindings["database"]; ok || comp.Type != TypeClair {
+			continue
+		}
+		if _, ok := instances[fmt.Sprintf("%s/%s", comp.NamePrefix, TypePostgres)]; !ok {
+			addf(
+				"%s.database: no postgres component with namePrefix %q, add a binding",
+				field, comp.NamePrefix,
+			)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateBackup checks the backup options of a component, returned problems are relative to
+// the backup options field.
+func validateBackup(comp Component) []string {
+	if comp.Type != TypePostgres {
+		return []string{fmt.Sprintf(" not supported by %s", comp.Type)}
+	}
+
+	var problems []string
+	bkp := comp.Options.Backup
+	if bkp.Retention < 0 {
+		problems = append(problems, ".retention must not be negative")
+	}
+	if bkp.S3 == nil {
+		return problems
+	}
+
+	if bkp.S3.Endpoint == "" {
+		problems = append(problems, ".s3.endpoint is required")
+	}
+	if bkp.S3.Bucket == "" {
+		problems = append(problems, ".s3.bucket is required")
+	}
+	if bkp.S3.SecretName == "" {
+		problems = append(problems, ".s3.secretName is required")
+	}
+	return problems
+}
+
+// Component returns the component with the provided name, nil if not found.
+func (m *Manifest) Component(name string) *Component {
+	for i := range m.Components {
+		if m.Components[i].Name == name {
+			return &m.Components[i]
+		}
+	}
+	return nil
+}
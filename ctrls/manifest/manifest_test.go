@@ -0,0 +1,247 @@
+package manifest_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ricardomaraschini/freighter/ctrls/manifest"
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+const stack = `
+apiVersion: freighter.io/v1alpha1
+kind: Stack
+components:
+  - name: database
+    type: postgres
+    namespace: quay
+    namePrefix: clair
+    overlay: backup
+    options:
+      backup:
+        schedule: "0 3 * * *"
+        retention: 14
+  - name: cache
+    type: redis
+  - name: clair
+    type: clair
+    namespace: quay
+    options:
+      image: quay.io/projectquay/clair:4.3.0
+      replicas: 2
+    bindings:
+      database: database
+`
+
+func TestParse(t *testing.T) {
+	man, err := manifest.Parse([]byte(stack))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	db := man.Component("database")
+	if db == nil {
+		t.Fatal("database component not found")
+	}
+	if db.NamePrefix != "clair" || db.Overlay != "backup" {
+		t.Errorf("database fields overwritten: %+v", db)
+	}
+	if db.Options.Backup == nil || db.Options.Backup.Retention != 14 {
+		t.Errorf("database backup options not parsed: %+v", db.Options.Backup)
+	}
+
+	cache := man.Component("cache")
+	if cache == nil {
+		t.Fatal("cache component not found")
+	}
+	if cache.Namespace != "default" {
+		t.Errorf("expected default namespace, got %q", cache.Namespace)
+	}
+	if cache.NamePrefix != "cache" {
+		t.Errorf("expected name prefix to default to name, got %q", cache.NamePrefix)
+	}
+	if cache.Overlay != mctrl.BaseOverlay {
+		t.Errorf("expected base overlay, got %q", cache.Overlay)
+	}
+
+	clair := man.Component("clair")
+	if clair == nil {
+		t.Fatal("clair component not found")
+	}
+	if clair.Options.Replicas == nil || *clair.Options.Replicas != 2 {
+		t.Errorf("clair replicas not parsed: %v", clair.Options.Replicas)
+	}
+
+	if man.Component("quay") != nil {
+		t.Error("unexpected component returned for unknown name")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		manifest string
+		problems []string
+	}{
+		{
+			name:     "unknown field",
+			manifest: "apiVersion: freighter.io/v1alpha1\nkind: Stack\nreplicas: 2\n",
+			problems: []string{`unknown field "replicas"`},
+		},
+		{
+			name:     "header and no components",
+			manifest: "apiVersion: v1\nkind: List\n",
+			problems: []string{
+				`apiVersion must be "freighter.io/v1alpha1"`,
+				`kind must be "Stack"`,
+				"no components",
+			},
+		},
+		{
+			name: "names and types",
+			manifest: `
+apiVersion: freighter.io/v1alpha1
+kind: Stack
+components:
+  - type: redis
+    namePrefix: cache
+  - name: db
+    type: mysql
+  - name: db
+    type: redis
+    namespace: Quay
+`,
+			problems: []string{
+				"components[0].name is required",
+				`components[1].type "mysql" is not one of postgres, redis or clair`,
+				`components[2].name "db" is duplicated`,
+				"components[2].namespace: a lowercase RFC 1123 label",
+			},
+		},
+		{
+			name: "same type and prefix",
+			manifest: `
+apiVersion: freighter.io/v1alpha1
+kind: Stack
+components:
+  - name: first
+    type: redis
+    namePrefix: cache
+    namespace: one
+  - name: second
+    type: redis
+    namePrefix: cache
+    namespace: two
+  - name: cache
+    type: postgres
+`,
+			problems: []string{`components[1] collides with "first" (same type and prefix)`},
+		},
+		{
+			name: "options",
+			manifest: `
+apiVersion: freighter.io/v1alpha1
+kind: Stack
+components:
+  - name: cache
+    type: redis
+    options:
+      replicas: -1
+      backup:
+        retention: 1
+  - name: db
+    type: postgres
+    options:
+      backup:
+        retention: -1
+        s3:
+          prefix: clair
+`,
+			problems: []string{
+				"components[0].options.replicas must not be negative",
+				"components[0].options.backup not supported by redis",
+				"components[1].options.backup.retention must not be negative",
+				"components[1].options.backup.s3.endpoint is required",
+				"components[1].options.backup.s3.bucket is required",
+				"components[1].options.backup.s3.secretName is required",
+			},
+		},
+		{
+			name: "bindings",
+			manifest: `
+apiVersion: freighter.io/v1alpha1
+kind: Stack
+components:
+  - name: cache
+    type: redis
+    bindings:
+      database: db
+  - name: clair
+    type: clair
+    bindings:
+      database: cache
+  - name: other
+    type: clair
+    bindings:
+      database: missing
+`,
+			problems: []string{
+				`components[0].bindings: redis does not accept binding "database"`,
+				`components[1].bindings.database: component "cache" is not a postgres`,
+				`components[2].bindings.database: component "missing" not found`,
+			},
+		},
+		{
+			name: "clair without database",
+			manifest: `
+apiVersion: freighter.io/v1alpha1
+kind: Stack
+components:
+  - name: database
+    type: postgres
+  - name: clair
+    type: clair
+`,
+			problems: []string{
+				`components[1].bindings.database: no postgres component with namePrefix "clair"`,
+			},
+		},
+		{
+			name: "clair bound by prefix",
+			manifest: `
+apiVersion: freighter.io/v1alpha1
+kind: Stack
+components:
+  - name: database
+    type: postgres
+    namePrefix: clair
+  - name: clair
+    type: clair
+`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := manifest.Parse([]byte(tt.manifest))
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			var verr *manifest.ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected validation error, got %v", err)
+			}
+			if len(verr.Problems) != len(tt.problems) {
+				t.Fatalf("expected %d problems, got %q", len(tt.problems), verr.Problems)
+			}
+			for i, problem := range tt.problems {
+				if !strings.Contains(verr.Problems[i], problem) {
+					t.Errorf("expected problem %q, got %q", problem, verr.Problems[i])
+				}
+			}
+		})
+	}
+}
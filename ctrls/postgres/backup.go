@@ -0,0 +1,216 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+//go:embed s3/kustomize/*
+var s3files embed.FS
+
+// BackupOverlay is the overlay that, on top of the base overlay, schedules backups of all
+// databases. By default dumps are kept in a dedicated claim that survives moving the controller
+// out of this overlay (it is only removed by Destroy), see WithBackupS3 for sending dumps to an
+// S3 compatible endpoint instead.
+const BackupOverlay = "backup"
+
+// ConditionBackupCompleted is the type of the condition reporting the last successful backup.
+// It is only present in the status while at BackupOverlay.
+const ConditionBackupCompleted = "BackupCompleted"
+
+// The following names refer to the backup objects as found in the embedded manifests.
+const (
+	backupName          = "database-backup"
+	backupContainerName = "backup"
+	uploadContainerName = "upload"
+)
+
+// S3Target is an S3 compatible endpoint (AWS S3, MinIO, etc) where backups are sent to. The
+// credentials secret must live in the controller namespace and contain the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY keys.
+type S3Target struct {
+	// Endpoint is the endpoint url, e.g. https://s3.amazonaws.com or http://minio:9000.
+	Endpoint string
+	// Bucket is the bucket backups are stored in, it must exist.
+	Bucket string
+	// Prefix is the path, inside the bucket, where backups are stored. Each backup is stored
+	// in its own directory, named after the time the backup started. Defaults to the
+	// controller name, e.g. <prefix>-postgres.
+	Prefix string
+	// SecretName is the name of the secret holding the credentials.
+	SecretName string
+}
+
+// backup holds the backup settings, zero values keep what is in the embedded manifests.
+type backup struct {
+	schedule  string
+	retention int
+	s3        *S3Target
+}
+
+// WithBackupSchedule sets the schedule, in cron format, for the backups taken at BackupOverlay.
+// Defaults to daily at 02:00.
+func WithBackupSchedule(schedule string) Option {
+	return func(p *Postgres) {
+		p.backup.schedule = schedule
+	}
+}
+
+// WithBackupRetention sets how many backups are kept, older backups are removed once a new one
+// succeeds. Defaults to seven, negative values are refused when rendering.
+func WithBackupRetention(count int) Option {
+	return func(p *Postgres) {
+		p.backup.retention = count
+	}
+}
+
+// WithBackupS3 makes backups taken at BackupOverlay go to an S3 compatible endpoint instead of a
+// claim. Dumps are taken into an ephemeral volume and then uploaded, retention is applied on the
+// bucket.
+func WithBackupS3(target S3Target) Option {
+	return func(p *Postgres) {
+		p.backup.s3 = &target
+		// s3files only holds the kustomize tree so this never fails.
+		sub, _ := fs.Sub(s3files, "s3")
+		mctrl.WithLayer(sub)(p.KustCtrl)
+	}
+}
+
+// mutateBackup prefixes the names of the backup objects, these live in BackupOverlay and do not
+// get the name prefix set in the base kustomization. Sets the database host, the claim and the
+// configured backup settings on the backup cron job. Other objects are left untouched.
+func (p *Postgres) mutateBackup(ctx context.Context, obj client.Object) error {
+	if obj.GetName() != backupName {
+		return nil
+	}
+	obj.SetName(p.backupName())
+
+	cron, ok := obj.(*batchv1.CronJob)
+	if !ok {
+		return nil
+	}
+
+	if p.backup.schedule != "" {
+		cron.Spec.Schedule = p.backup.schedule
+	}
+
+	if p.backup.retention < 0 {
+		return fmt.Errorf("invalid backup retention %d", p.backup.retention)
+	}
+
+	env := map[string]string{
+		"PGHOST": fmt.Sprintf("%s-database", p.namePrefix),
+	}
+	if p.backup.retention > 0 {
+		env["BACKUP_RETENTION"] = strconv.Itoa(p.backup.retention)
+	}
+
+	if p.backup.s3 != nil {
+		prefix := p.backup.s3.Prefix
+		if prefix == "" {
+			prefix = p.Name()
+		}
+		env["S3_ENDPOINT"] = p.backup.s3.Endpoint
+		env["S3_BUCKET"] = p.backup.s3.Bucket
+		env["S3_PREFIX"] = prefix
+	}
+
+	spec := &cron.Spec.JobTemplate.Spec.Template.Spec
+	for _, vol := range spec.Volumes {
+		if pvc := vol.PersistentVolumeClaim; pvc != nil && pvc.ClaimName == backupName {
+			pvc.ClaimName = p.backupName()
+		}
+	}
+
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			container := &containers[i]
+			if container.Name != backupContainerName && container.Name != uploadContainerName {
+				continue
+			}
+
+			for j := range container.Env {
+				if val, ok := env[container.Env[j].Name]; ok {
+					container.Env[j].Value = val
+				}
+			}
+
+			if p.backup.s3 == nil {
+				continue
+			}
+			for j := range container.EnvFrom {
+				if ref := container.EnvFrom[j].SecretRef; ref != nil {
+					ref.Name = p.backup.s3.SecretName
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// LastBackup returns the time of the last successful backup, nil if no backup has succeeded yet
+// or if backups are not scheduled (the controller is not at BackupOverlay).
+func (p *Postgres) LastBackup(ctx context.Context) (*metav1.Time, error) {
+	if p.Overlay() != BackupOverlay {
+		return nil, nil
+	}
+
+	nsn := types.NamespacedName{
+		Namespace: p.namespace,
+		Name:      p.backupName(),
+	}
+
+	var cron batchv1.CronJob
+	if err := p.Reader().Get(ctx, nsn, &cron); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to get backup cron job: %w", err)
+	}
+	return cron.Status.LastSuccessfulTime, nil
+}
+
+// backupName returns the name of the backup cron job and claim.
+func (p *Postgres) backupName() string {
+	return fmt.Sprintf("%s-%s", p.namePrefix, backupName)
+}
+
+// backupCondition returns the condition reporting the last successful backup.
+func (p *Postgres) backupCondition(ctx context.Context) (metav1.Condition, error) {
+	last, err := p.LastBackup(ctx)
+	if err != nil {
+		return metav1.Condition{}, err
+	}
+
+	if last == nil {
+		return metav1.Condition{
+			Type:               ConditionBackupCompleted,
+			Status:             metav1.ConditionFalse,
+			Reason:             "NoBackupYet",
+			Message:            "no backup has succeeded yet",
+			LastTransitionTime: metav1.Now(),
+		}, nil
+	}
+
+	msg := fmt.Sprintf("last successful backup at %s", last.UTC().Format(time.RFC3339))
+	return metav1.Condition{
+		Type:               ConditionBackupCompleted,
+		Status:             metav1.ConditionTrue,
+		Reason:             "BackupSucceeded",
+		Message:            msg,
+		LastTransitionTime: *last,
+	}, nil
+}
@@ -3,8 +3,22 @@ package postgres
 import (
 	"context"
 
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// The following names refer to the objects as found in the embedded manifests.
+const (
+	imageName      = "centos/postgresql-10-centos7"
+	deploymentName = "database"
+	containerName  = "postgres"
 )
 
 // Option is a function capable of set an optional parameter.
@@ -46,3 +60,58 @@ func WithNamePrefix(prefix string) Option {
 		p.namePrefix = prefix
 	}
 }
+
+// WithKustOptions passes provided options to the underlying kustomize controller.
+func WithKustOptions(opts ...mctrl.KustOption) Option {
+	return func(p *Postgres) {
+		for _, opt := range opts {
+			opt(p.KustCtrl)
+		}
+	}
+}
+
+// WithLogger sets the logger used by the controller, see mctrl.WithLogger.
+func WithLogger(logger logr.Logger) Option {
+	return func(p *Postgres) {
+		mctrl.WithLogger(logger)(p.KustCtrl)
+	}
+}
+
+// WithEventRecorder makes the controller emit events on the provided owner, see
+// mctrl.WithEventRecorder.
+func WithEventRecorder(recorder record.EventRecorder, owner runtime.Object) Option {
+	return func(p *Postgres) {
+		mctrl.WithEventRecorder(recorder, owner)(p.KustCtrl)
+	}
+}
+
+// WithImage replaces the postgres image by the provided image reference, the reference may carry
+// a tag or a digest.
+func WithImage(image string) Option {
+	return func(p *Postgres) {
+		mctrl.WithImage(imageName, image)(p.KustCtrl)
+	}
+}
+
+// WithReplicas sets the number of postgres replicas for the base overlay. Postgres stores its data
+// in a ReadWriteOnce volume so running more than one replica is not supported.
+func WithReplicas(count int64) Option {
+	return func(p *Postgres) {
+		mctrl.WithReplicas(deploymentName, count)(p.KustCtrl)
+	}
+}
+
+// WithResources sets the resource requests and limits for the postgres container.
+func WithResources(res corev1.ResourceRequirements) Option {
+	return func(p *Postgres) {
+		mctrl.WithResources("Deployment", deploymentName, containerName, res)(p.KustCtrl)
+	}
+}
+
+// WithPatches adds strategic merge or JSON6902 patches to the postgres base kustomization. Patch
+// targets refer to objects by the names found in the manifests (without the name prefix).
+func WithPatches(patches ...ktypes.Patch) Option {
+	return func(p *Postgres) {
+		mctrl.WithPatches(patches...)(p.KustCtrl)
+	}
+}
@@ -22,9 +22,14 @@ import (
 //go:embed kustomize/*
 var kfiles embed.FS
 
+// EventPasswordsGenerated is the reason of the event emitted when the pgsql passwords are
+// generated, see mctrl.WithEventRecorder.
+const EventPasswordsGenerated = "PasswordsGenerated"
+
 // New returns a new Postgres controller. This creates a postgresq deployment, a pvc, a service
 // and a service account. If you want to have more than one postgres instance in the same
 // namespace you have to configure this to use different name prefixes, see WithNamePrefix option.
+// Backups are scheduled by moving the controller to BackupOverlay.
 func New(cli client.Client, opts ...Option) *Postgres {
 	pg := &Postgres{
 		KustCtrl:   mctrl.NewKustCtrl(cli, kfiles),
@@ -34,10 +39,13 @@ func New(cli client.Client, opts ...Option) *Postgres {
 	}
 
 	pg.KMutators = append(pg.KMutators, pg.mutateKustomization)
+	pg.OMutators = append(pg.OMutators, pg.mutateBackup)
 
 	for _, opt := range opts {
 		opt(pg)
 	}
+
+	pg.SetIdentity(pg.namespace, fmt.Sprintf("%s-postgres", pg.namePrefix))
 	return pg
 }
 
@@ -53,16 +61,18 @@ type Postgres struct {
 	ownerRef   *metav1.OwnerReference
 	namespace  string
 	namePrefix string
+	backup     backup
 }
 
 // mutateKustomization makes sure we append a prefix to created objects and that we also populate
 // a secret with the necessary database secret data. Passwords are kept in two different secrets,
 // one if for this controller consumption and the other is a Generated Secret, the latter is then
-// mounted in the postgresq deployment.
+// mounted in the postgresq deployment. When rendering only the passwords are read from the
+// existing secret, if there is one, otherwise placeholders are used.
 func (p *Postgres) mutateKustomization(
-	ctx context.Context, kust *ktypes.Kustomization, ad mctrl.Ads,
+	ctx context.Context, kust *ktypes.Kustomization, ad *mctrl.Ads,
 ) error {
-	pass, rootpass, err := p.ensurePsqlSecretData(ctx)
+	pass, rootpass, err := p.psqlSecretData(ctx)
 	if err != nil {
 		return fmt.Errorf("error ensuring pgsql secret data: %w", err)
 	}
@@ -88,47 +98,110 @@ func (p *Postgres) mutateKustomization(
 	return nil
 }
 
+// AdNames holds the names of all indexes advertised by this controller. Indexes are advertised
+// scoped, see Scope.
+var AdNames = []string{"dbhost", "dbport", "dbuser", "dbpass", "dbname", "dbrootuser", "dbrootpass"}
+
+// Scope returns the scope under which this controller instance advertises its data.
+func (p *Postgres) Scope() mctrl.Scope {
+	return mctrl.Scope{
+		Prefix:    p.namePrefix,
+		Component: "postgres",
+	}
+}
+
+// Provides returns the scoped indexes advertised by this controller instance.
+func (p *Postgres) Provides() []string {
+	return p.Scope().Keys(AdNames...)
+}
+
 // Advertise advertises postgres address (service name), port, user, passowrd and database
-// name. Advertises postgres' admin user and password as well.
-func (p *Postgres) Advertise(ctx context.Context) (mctrl.Ads, error) {
-	var ad mctrl.Ads
+// name. Advertises postgres' admin user and password as well. All indexes are advertised under
+// this controller Scope, e.g. <prefix>/postgres/dbhost.
+func (p *Postgres) Advertise(ctx context.Context) (*mctrl.Ads, error) {
+	return p.advertise(ctx, p.Overlay())
+}
+
+// RenderAds returns what this controller would advertise once moved to the provided overlay.
+// Nothing is written to the cluster, if passwords have not been generated yet placeholders are
+// advertised instead.
+func (p *Postgres) RenderAds(ctx context.Context, overlay string) (*mctrl.Ads, error) {
+	return p.advertise(mctrl.RenderOnly(ctx), overlay)
+}
+
+// advertise returns the Ads for the provided overlay.
+func (p *Postgres) advertise(ctx context.Context, overlay string) (*mctrl.Ads, error) {
+	ad := mctrl.NewAds()
 
 	// if scaling down or not deployed advertises nothing.
-	if p.Overlay() == mctrl.ScaleDownOverlay || p.Overlay() == mctrl.NotAppliedOverlay {
+	if overlay == mctrl.ScaleDownOverlay || overlay == mctrl.NotAppliedOverlay {
 		return ad, nil
 	}
 
-	pass, rootpass, err := p.ensurePsqlSecretData(ctx)
+	pass, rootpass, err := p.psqlSecretData(ctx)
 	if err != nil {
 		return ad, fmt.Errorf("error reading pgsql secret data: %w", err)
 	}
 
-	ad.Put("dbhost", fmt.Sprintf("%s-database.%s.svc", p.namePrefix, p.namespace))
-	ad.Put("dbport", "5432")
-	ad.Put("dbuser", "user")
-	ad.Put("dbpass", pass)
-	ad.Put("dbname", "database")
-	ad.Put("dbrootuser", "postgres")
-	ad.Put("dbrootpass", rootpass)
+	scope := p.Scope()
+	ad.Put(scope.Key("dbhost"), fmt.Sprintf("%s-database.%s.svc", p.namePrefix, p.namespace))
+	ad.Put(scope.Key("dbport"), "5432")
+	ad.Put(scope.Key("dbuser"), "user")
+	ad.PutSensitive(scope.Key("dbpass"), pass)
+	ad.Put(scope.Key("dbname"), "database")
+	ad.Put(scope.Key("dbrootuser"), "postgres")
+	ad.PutSensitive(scope.Key("dbrootpass"), rootpass)
 	return ad, nil
 }
 
+// psqlSecretData returns the user and root passwords. Passwords are read, and generated if
+// needed, through ensurePsqlSecretData unless the context is flagged as render only, in such
+// case they are only read and placeholders are returned if they do not exist yet.
+func (p *Postgres) psqlSecretData(ctx context.Context) (string, string, error) {
+	if !mctrl.IsRenderOnly(ctx) {
+		return p.ensurePsqlSecretData(ctx)
+	}
+
+	sct, err := p.readPsqlSecret(ctx)
+	if err != nil {
+		return "", "", err
+	} else if sct == nil {
+		return "<generated-password>", "<generated-root-password>", nil
+	}
+	return string(sct.Data["pass"]), string(sct.Data["rootpass"]), nil
+}
+
+// readPsqlSecret reads the secret holding pgsql access data. Returns nil if the secret does
+// not exist.
+func (p *Postgres) readPsqlSecret(ctx context.Context) (*corev1.Secret, error) {
+	var sct corev1.Secret
+	if err := p.client.Get(ctx, p.psqlSecretName(), &sct); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading pgsql access data: %w", err)
+	}
+	return &sct, nil
+}
+
+// psqlSecretName returns the namespaced name for the secret holding pgsql access data.
+func (p *Postgres) psqlSecretName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: p.namespace,
+		Name:      fmt.Sprintf("%s-pgsql-access-data", p.namePrefix),
+	}
+}
+
 // ensurePsqlSecretData makes sure we have created a secret to store pgsql access data. We have
 // to keep this secret around so we don't keep regenerating passwords every time we Apply some
 // different overlay. Returns the user and root passwords as strings after storing them in the
 // kubernetes secret. If the secret already exists this function only reads its values.
 func (p *Postgres) ensurePsqlSecretData(ctx context.Context) (string, string, error) {
-	nsn := types.NamespacedName{
-		Namespace: p.namespace,
-		Name:      fmt.Sprintf("%s-pgsql-access-data", p.namePrefix),
-	}
-
-	var sct corev1.Secret
-	err := p.client.Get(ctx, nsn, &sct)
-	if err == nil {
-		return string(sct.Data["pass"]), string(sct.Data["rootpass"]), nil
-	} else if !errors.IsNotFound(err) {
-		return "", "", fmt.Errorf("error reading pgsql access data: %w", err)
+	cur, err := p.readPsqlSecret(ctx)
+	if err != nil {
+		return "", "", err
+	} else if cur != nil {
+		return string(cur.Data["pass"]), string(cur.Data["rootpass"]), nil
 	}
 
 	// generates new random password and root password.
@@ -137,6 +210,9 @@ func (p *Postgres) ensurePsqlSecretData(ctx context.Context) (string, string, er
 		"rootpass": uuid.New().String(),
 	}
 
+	nsn := p.psqlSecretName()
+
+	var sct corev1.Secret
 	sct.Name = nsn.Name
 	sct.Namespace = nsn.Namespace
 	sct.StringData = data
@@ -147,24 +223,55 @@ func (p *Postgres) ensurePsqlSecretData(ctx context.Context) (string, string, er
 	if err := p.client.Create(ctx, &sct); err != nil {
 		return "", "", fmt.Errorf("error creating pgsql secret data: %w", err)
 	}
+
+	p.Logger(ctx).Info("generated pgsql passwords", mctrl.LogKeyName, nsn.Name)
+	p.Eventf(
+		corev1.EventTypeNormal, EventPasswordsGenerated,
+		"generated pgsql passwords, stored in secret %s", nsn.Name,
+	)
 	return data["pass"], data["rootpass"], nil
 }
 
-// Status return the status for this component at the current overlay. Inspects the postgres
-// deployment and sees if the number of available replicas is equal to the number of requested
-// replicas. Returns postgres conditions as controller conditions.
+// Destroy deletes all objects created by this controller, including the secret holding the
+// generated passwords. Once destroyed a new Apply generates new passwords.
+func (p *Postgres) Destroy(ctx context.Context) error {
+	if err := p.KustCtrl.Destroy(ctx); err != nil {
+		return err
+	}
+
+	nsn := p.psqlSecretName()
+	var sct corev1.Secret
+	sct.Name = nsn.Name
+	sct.Namespace = nsn.Namespace
+	if err := p.client.Delete(ctx, &sct); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting pgsql secret data: %w", err)
+	}
+	return nil
+}
+
+// Status return the status for this component at the current overlay. All applied objects must
+// be ready according to KustCtrl.Status, then inspects the postgres deployment and sees if the
+// number of available replicas is equal to the number of requested replicas. Returns postgres
+// conditions as controller conditions, at BackupOverlay the ConditionBackupCompleted condition
+// is added.
 func (p *Postgres) Status(ctx context.Context) (*mctrl.Status, error) {
 	if p.Overlay() == mctrl.NotAppliedOverlay {
 		return nil, fmt.Errorf("no overlay applied to the controller")
 	}
 
+	if status, err := p.KustCtrl.Status(ctx); err != nil {
+		return nil, err
+	} else if !status.Ready {
+		return status, nil
+	}
+
 	nsn := types.NamespacedName{
 		Namespace: p.namespace,
 		Name:      fmt.Sprintf("%s-database", p.namePrefix),
 	}
 
 	var dep appsv1.Deployment
-	if err := p.client.Get(ctx, nsn, &dep); err != nil {
+	if err := p.Reader().Get(ctx, nsn, &dep); err != nil {
 		return nil, fmt.Errorf("unable to get deployment: %w", err)
 	}
 
@@ -177,6 +284,16 @@ func (p *Postgres) Status(ctx context.Context) (*mctrl.Status, error) {
 		conds = append(conds, mv1cond)
 	}
 
+	// at the backup overlay the last successful backup is reported as a condition, it does
+	// not affect readiness.
+	if p.Overlay() == BackupOverlay {
+		cond, err := p.backupCondition(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error reading backup status: %w", err)
+		}
+		conds = append(conds, cond)
+	}
+
 	// if we are not scaled down just check if the number of AvailableReplicas is equal
 	// to the number of requested replicas (spec.Replicas).
 	if p.Overlay() != mctrl.ScaleDownOverlay {
@@ -221,16 +338,22 @@ func (p *Postgres) Status(ctx context.Context) (*mctrl.Status, error) {
 }
 
 // hasDanglingPods checks if a deployment contains any pod dangling online. Verifies through
-// all replicasets owned by the deployment.
+// all replicasets owned by the deployment. Only replicasets and pods labeled as belonging to this
+// instance are listed.
 func (p *Postgres) hasDanglingPods(ctx context.Context, dep appsv1.Deployment) (bool, error) {
+	opts := []client.ListOption{
+		client.InNamespace(p.namespace),
+		client.MatchingLabels{mctrl.InstanceLabel: p.Name()},
+	}
+
 	var rsets appsv1.ReplicaSetList
-	if err := p.client.List(ctx, &rsets, client.InNamespace(p.namespace)); err != nil {
+	if err := p.Reader().List(ctx, &rsets, opts...); err != nil {
 		return false, fmt.Errorf("error listing replicasets: %w", err)
 	}
 
 	var pods corev1.PodList
-	if err := p.client.List(ctx, &pods, client.InNamespace(p.namespace)); err != nil {
-		return false, fmt.Errorf("error listing replicasets: %w", err)
+	if err := p.Reader().List(ctx, &pods, opts...); err != nil {
+		return false, fmt.Errorf("error listing pods: %w", err)
 	}
 
 	// captures the uids for all replicasets owned by the deployment in a map.
@@ -0,0 +1,137 @@
+package postgres
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+)
+
+// overlays lists all overlays provided by the controller besides mctrl.BaseOverlay.
+var overlays = []string{mctrl.ScaleDownOverlay, BackupOverlay}
+
+// minio is the S3 target used in tests, a MinIO instance stands in for the S3 endpoint.
+var minio = S3Target{
+	Endpoint:   "http://minio.test.svc:9000",
+	Bucket:     "backups",
+	SecretName: "minio-credentials",
+}
+
+// targets maps the backup targets into the options selecting them.
+var targets = map[string][]Option{
+	"claim": nil,
+	"s3":    {WithBackupS3(minio)},
+}
+
+// newHarness returns a harness whose default storage class binds claims on the first consumer,
+// as most cloud providers do, holding the MinIO credentials.
+func newHarness() *mctrltest.Harness {
+	mode := storagev1.VolumeBindingWaitForFirstConsumer
+	return mctrltest.NewHarness(
+		"test",
+		&storagev1.StorageClass{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "standard",
+				Annotations: map[string]string{
+					mctrl.DefaultClassAnnotation: "true",
+				},
+			},
+			Provisioner:       "kubernetes.io/no-provisioner",
+			VolumeBindingMode: &mode,
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: minio.SecretName},
+			StringData: map[string]string{
+				"AWS_ACCESS_KEY_ID":     "minio",
+				"AWS_SECRET_ACCESS_KEY": "minio123",
+			},
+		},
+	)
+}
+
+// newPostgres returns a controller for the test prefix in the harness namespace.
+func newPostgres(cli client.Client, opts ...Option) *Postgres {
+	return New(cli, append([]Option{WithNamespace("test"), WithNamePrefix("test")}, opts...)...)
+}
+
+func TestConformance(t *testing.T) {
+	for target, opts := range targets {
+		opts := opts
+		t.Run(target, func(t *testing.T) {
+			suite := &mctrltest.Conformance{
+				Harness: newHarness(),
+				New: func(cli client.Client) mctrl.MicroController {
+					return newPostgres(cli, opts...)
+				},
+				Provides: newPostgres(nil).Provides(),
+				Overlays: overlays,
+			}
+			suite.Run(t)
+		})
+	}
+}
+
+func TestGolden(t *testing.T) {
+	for _, overlay := range append([]string{mctrl.BaseOverlay}, overlays...) {
+		t.Run(overlay, func(t *testing.T) {
+			pg := newPostgres(newHarness().Client)
+			mctrltest.AssertGoldenOverlay(
+				t, pg, overlay, mctrl.NewAds(), filepath.Join("testdata", overlay+".yaml"),
+			)
+		})
+	}
+
+	t.Run("backup-s3", func(t *testing.T) {
+		pg := newPostgres(newHarness().Client, WithBackupS3(minio))
+		mctrltest.AssertGoldenOverlay(
+			t, pg, BackupOverlay, mctrl.NewAds(), "testdata/backup-s3.yaml",
+		)
+	})
+}
+
+// TestBackupClaimFirstConsumer checks that the backup claim, only used by the backup cron job,
+// does not hold readiness back while waiting for its first consumer.
+func TestBackupClaimFirstConsumer(t *testing.T) {
+	ctx := context.Background()
+	harness := newHarness()
+	pg := newPostgres(harness.Client)
+
+	if err := pg.Apply(ctx, BackupOverlay, mctrl.NewAds()); err != nil {
+		t.Fatalf("error applying: %s", err)
+	}
+	if err := harness.Settle(ctx); err != nil {
+		t.Fatalf("error settling workloads: %s", err)
+	}
+
+	var pvc corev1.PersistentVolumeClaim
+	nsn := types.NamespacedName{Namespace: "test", Name: pg.backupName()}
+	if err := harness.Client.Get(ctx, nsn, &pvc); err != nil {
+		t.Fatalf("error reading backup claim: %s", err)
+	}
+	if pvc.Status.Phase == corev1.ClaimBound {
+		t.Fatal("backup claim bound without a consumer")
+	}
+
+	status, err := pg.Status(ctx)
+	if err != nil {
+		t.Fatalf("error reading status: %s", err)
+	}
+	if !status.Ready {
+		t.Fatalf("not ready: %s", status.Message)
+	}
+
+	last, err := pg.LastBackup(ctx)
+	if err != nil {
+		t.Fatalf("error reading last backup: %s", err)
+	} else if last != nil {
+		t.Fatalf("last backup reported before any backup: %s", last)
+	}
+}
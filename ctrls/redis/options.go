@@ -3,8 +3,22 @@ package redis
 import (
 	"context"
 
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// The following names refer to the objects as found in the embedded manifests.
+const (
+	imageName      = "centos/redis-32-centos7"
+	deploymentName = "redis"
+	containerName  = "redis-master"
 )
 
 // Option is a function capable of set an optional parameter.
@@ -45,3 +59,57 @@ func WithNamePrefix(prefix string) Option {
 		r.namePrefix = prefix
 	}
 }
+
+// WithKustOptions passes provided options to the underlying kustomize controller.
+func WithKustOptions(opts ...mctrl.KustOption) Option {
+	return func(r *Redis) {
+		for _, opt := range opts {
+			opt(r.KustCtrl)
+		}
+	}
+}
+
+// WithLogger sets the logger used by the controller, see mctrl.WithLogger.
+func WithLogger(logger logr.Logger) Option {
+	return func(r *Redis) {
+		mctrl.WithLogger(logger)(r.KustCtrl)
+	}
+}
+
+// WithEventRecorder makes the controller emit events on the provided owner, see
+// mctrl.WithEventRecorder.
+func WithEventRecorder(recorder record.EventRecorder, owner runtime.Object) Option {
+	return func(r *Redis) {
+		mctrl.WithEventRecorder(recorder, owner)(r.KustCtrl)
+	}
+}
+
+// WithImage replaces the redis image by the provided image reference, the reference may carry
+// a tag or a digest.
+func WithImage(image string) Option {
+	return func(r *Redis) {
+		mctrl.WithImage(imageName, image)(r.KustCtrl)
+	}
+}
+
+// WithReplicas sets the number of redis replicas for the base overlay.
+func WithReplicas(count int64) Option {
+	return func(r *Redis) {
+		mctrl.WithReplicas(deploymentName, count)(r.KustCtrl)
+	}
+}
+
+// WithResources sets the resource requests and limits for the redis container.
+func WithResources(res corev1.ResourceRequirements) Option {
+	return func(r *Redis) {
+		mctrl.WithResources("Deployment", deploymentName, containerName, res)(r.KustCtrl)
+	}
+}
+
+// WithPatches adds strategic merge or JSON6902 patches to the redis base kustomization. Patch
+// targets refer to objects by the names found in the manifests (without the name prefix).
+func WithPatches(patches ...ktypes.Patch) Option {
+	return func(r *Redis) {
+		mctrl.WithPatches(patches...)(r.KustCtrl)
+	}
+}
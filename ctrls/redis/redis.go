@@ -5,14 +5,10 @@ import (
 	"embed"
 	"fmt"
 
-	appsv1 "k8s.io/api/apps/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ktypes "sigs.k8s.io/kustomize/api/types"
 
 	"github.com/ricardomaraschini/freighter/infra/mctrl"
-	"github.com/ricardomaraschini/freighter/infra/resource"
 )
 
 //go:embed kustomize/*
@@ -34,10 +30,13 @@ func New(cli client.Client, opts ...Option) *Redis {
 	for _, opt := range opts {
 		opt(rs)
 	}
+
+	rs.SetIdentity(rs.namespace, fmt.Sprintf("%s-redis", rs.namePrefix))
 	return rs
 }
 
-// Redis controls a redis deployment. Deploys a redis server and keeps track of its status.
+// Redis controls a redis deployment. Deploys a redis server and keeps track of its status (status
+// is computed by the embedded KustCtrl based on all objects it applied).
 // Advertises the redis service address. Redis implements mctrl.MicroController interface so other
 // controlers can use when configuring third party applications.
 type Redis struct {
@@ -51,66 +50,49 @@ type Redis struct {
 // mutateKustomization mutates the base Kustomization for a Redis deployment. Only appends the
 // provided name prefix.
 func (r *Redis) mutateKustomization(
-	ctx context.Context, kust *ktypes.Kustomization, adv mctrl.Ads,
+	ctx context.Context, kust *ktypes.Kustomization, adv *mctrl.Ads,
 ) error {
 	kust.NamePrefix = fmt.Sprintf("%s-", r.namePrefix)
 	return nil
 }
 
-// Advertise returns data this component advertises. This component advertises only the redis
-// address (service address) and port.
-func (r *Redis) Advertise(ctx context.Context) (mctrl.Ads, error) {
-	var adv mctrl.Ads
-	if r.Overlay() == mctrl.ScaleDownOverlay || r.Overlay() == mctrl.NotAppliedOverlay {
-		return adv, nil
-	}
-
-	adv.Put("address", fmt.Sprintf("%s-redis.%s.svc", r.namePrefix, r.namespace))
-	adv.Put("port", "6379")
-	return adv, nil
-}
-
-// Status return the status for this component at the last applied overlay.
-func (r *Redis) Status(ctx context.Context) (*mctrl.Status, error) {
-	if r.Overlay() == mctrl.NotAppliedOverlay {
-		return nil, fmt.Errorf("no overlay applied to the controller")
-	}
+// AdNames holds the names of all indexes advertised by this controller. Indexes are advertised
+// scoped, see Scope.
+var AdNames = []string{"address", "port"}
 
-	nsn := types.NamespacedName{
-		Namespace: r.namespace,
-		Name:      fmt.Sprintf("%s-redis", r.namePrefix),
+// Scope returns the scope under which this controller instance advertises its data.
+func (r *Redis) Scope() mctrl.Scope {
+	return mctrl.Scope{
+		Prefix:    r.namePrefix,
+		Component: "redis",
 	}
+}
 
-	var dep appsv1.Deployment
-	if err := r.client.Get(ctx, nsn, &dep); err != nil {
-		return nil, fmt.Errorf("error getting deployment: %w", err)
-	}
+// Provides returns the scoped indexes advertised by this controller instance.
+func (r *Redis) Provides() []string {
+	return r.Scope().Keys(AdNames...)
+}
 
-	var replicas int32
-	if r.Overlay() != mctrl.ScaleDownOverlay && dep.Spec.Replicas != nil {
-		replicas = *dep.Spec.Replicas
-	}
+// Advertise returns data this component advertises. This component advertises only the redis
+// address (service address) and port, both under this controller Scope.
+func (r *Redis) Advertise(ctx context.Context) (*mctrl.Ads, error) {
+	return r.advertise(r.Overlay()), nil
+}
 
-	var conds []metav1.Condition
-	for _, cond := range dep.Status.Conditions {
-		mv1cond, err := resource.ToCondition(cond)
-		if err != nil {
-			return nil, fmt.Errorf("error converting condition: %w", err)
-		}
-		conds = append(conds, mv1cond)
-	}
+// RenderAds returns what this controller would advertise once moved to the provided overlay.
+func (r *Redis) RenderAds(ctx context.Context, overlay string) (*mctrl.Ads, error) {
+	return r.advertise(overlay), nil
+}
 
-	if dep.Status.AvailableReplicas != replicas {
-		return &mctrl.Status{
-			Ready:      false,
-			Message:    "deployment not fully available yet",
-			Conditions: conds,
-		}, nil
+// advertise returns the Ads for the provided overlay.
+func (r *Redis) advertise(overlay string) *mctrl.Ads {
+	adv := mctrl.NewAds()
+	if overlay == mctrl.ScaleDownOverlay || overlay == mctrl.NotAppliedOverlay {
+		return adv
 	}
 
-	return &mctrl.Status{
-		Ready:      true,
-		Message:    "deployment ready",
-		Conditions: conds,
-	}, nil
+	scope := r.Scope()
+	adv.Put(scope.Key("address"), fmt.Sprintf("%s-redis.%s.svc", r.namePrefix, r.namespace))
+	adv.Put(scope.Key("port"), "6379")
+	return adv
 }
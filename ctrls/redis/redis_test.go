@@ -0,0 +1,39 @@
+package redis
+
+import (
+	"path/filepath"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+)
+
+// overlays lists all overlays provided by the controller besides mctrl.BaseOverlay.
+var overlays = []string{mctrl.ScaleDownOverlay}
+
+func TestConformance(t *testing.T) {
+	harness := mctrltest.NewHarness("test")
+	suite := &mctrltest.Conformance{
+		Harness: harness,
+		New: func(cli client.Client) mctrl.MicroController {
+			return New(cli, WithNamespace(harness.Namespace), WithNamePrefix("test"))
+		},
+		Provides: New(nil, WithNamePrefix("test")).Provides(),
+		Overlays: overlays,
+	}
+	suite.Run(t)
+}
+
+func TestGolden(t *testing.T) {
+	for _, overlay := range append([]string{mctrl.BaseOverlay}, overlays...) {
+		t.Run(overlay, func(t *testing.T) {
+			harness := mctrltest.NewHarness("test")
+			rs := New(harness.Client, WithNamespace(harness.Namespace), WithNamePrefix("test"))
+			mctrltest.AssertGoldenOverlay(
+				t, rs, overlay, mctrl.NewAds(), filepath.Join("testdata", overlay+".yaml"),
+			)
+		})
+	}
+}
@@ -3,11 +3,17 @@ module github.com/ricardomaraschini/freighter
 go 1.16
 
 require (
+	github.com/go-logr/logr v0.4.0
 	github.com/google/uuid v1.3.0
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/prometheus/client_golang v1.11.0
+	go.uber.org/zap v1.19.0
 	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/api v0.22.1
 	k8s.io/apimachinery v0.22.1
+	k8s.io/client-go v0.22.1
 	sigs.k8s.io/controller-runtime v0.10.0
 	sigs.k8s.io/kustomize/api v0.9.0
 	sigs.k8s.io/kustomize/kyaml v0.11.1
+	sigs.k8s.io/yaml v1.2.0
 )
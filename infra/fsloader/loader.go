@@ -1,47 +1,58 @@
 package fsloader
 
 import (
-	"embed"
 	"fmt"
+	"io/fs"
 	"path"
 
 	"sigs.k8s.io/kustomize/api/filesys"
 )
 
-// Load loads an embed FS struct into an in memory kustomize file system representation. Reads
-// all files from the embed and writes them to the FileSystem struct.
-func Load(content embed.FS) (filesys.FileSystem, error) {
-	fs := filesys.MakeFsInMemory()
-	if err := readdir(".", content, fs); err != nil {
-		return nil, fmt.Errorf("error loading embed files: %w", err)
+// Load loads a fs.FS (e.g. an embed.FS or an os.DirFS) into an in memory kustomize file system
+// representation. Reads all files from the fs.FS and writes them to the FileSystem struct.
+func Load(content fs.FS) (filesys.FileSystem, error) {
+	virtfs := filesys.MakeFsInMemory()
+	if err := Layer(virtfs, content, "."); err != nil {
+		return nil, err
 	}
-	return fs, nil
+	return virtfs, nil
 }
 
-// readdir reads a directory recursively from provided embed instance, copying everything into
-// a fs.FileSystem object. Any error aborts the process and 'to' is left in an unknown state.
-func readdir(dir string, from embed.FS, to filesys.FileSystem) error {
-	entries, err := from.ReadDir(dir)
+// Layer copies all files from a fs.FS into provided FileSystem under the 'at' directory. Files
+// already present in the FileSystem are overwritten, this allows for layering multiple trees on
+// top of each other (e.g. a directory on disk on top of an embedded tree).
+func Layer(to filesys.FileSystem, from fs.FS, at string) error {
+	if err := readdir(".", at, from, to); err != nil {
+		return fmt.Errorf("error loading files: %w", err)
+	}
+	return nil
+}
+
+// readdir reads a directory recursively from provided fs.FS instance, copying everything into
+// a fs.FileSystem object under the 'at' directory. Any error aborts the process and 'to' is left
+// in an unknown state.
+func readdir(dir, at string, from fs.FS, to filesys.FileSystem) error {
+	entries, err := fs.ReadDir(from, dir)
 	if err != nil {
 		return fmt.Errorf("error reading dir: %w", err)
 	}
 
 	for _, entry := range entries {
-		path := path.Join(dir, entry.Name())
+		fpath := path.Join(dir, entry.Name())
 
 		if entry.IsDir() {
-			if err := readdir(path, from, to); err != nil {
+			if err := readdir(fpath, at, from, to); err != nil {
 				return err
 			}
 			continue
 		}
 
-		fcontent, err := from.ReadFile(path)
+		fcontent, err := fs.ReadFile(from, fpath)
 		if err != nil {
 			return fmt.Errorf("error reading file: %w", err)
 		}
 
-		if err := to.WriteFile(path, fcontent); err != nil {
+		if err := to.WriteFile(path.Join(at, fpath), fcontent); err != nil {
 			return fmt.Errorf("error writing file: %w", err)
 		}
 	}
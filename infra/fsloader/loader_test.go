@@ -0,0 +1,70 @@
+package fsloader_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/ricardomaraschini/freighter/infra/fsloader"
+)
+
+func TestLoad(t *testing.T) {
+	vfs, err := fsloader.Load(fstest.MapFS{
+		"base/kustomization.yaml":  {Data: []byte("resources: [deploy.yaml]")},
+		"base/deploy.yaml":         {Data: []byte("kind: Deployment")},
+		"overlays/scale/kust.yaml": {Data: []byte("replicas: 0")},
+	})
+	if err != nil {
+		t.Fatalf("error loading: %s", err)
+	}
+
+	for fpath, expected := range map[string]string{
+		"base/kustomization.yaml":  "resources: [deploy.yaml]",
+		"base/deploy.yaml":         "kind: Deployment",
+		"overlays/scale/kust.yaml": "replicas: 0",
+	} {
+		content, err := vfs.ReadFile(fpath)
+		if err != nil {
+			t.Errorf("error reading %s: %s", fpath, err)
+			continue
+		}
+		if string(content) != expected {
+			t.Errorf("%s: expected %q, got %q", fpath, expected, content)
+		}
+	}
+}
+
+func TestLayer(t *testing.T) {
+	vfs, err := fsloader.Load(fstest.MapFS{
+		"base/deploy.yaml":  {Data: []byte("image: embedded")},
+		"base/service.yaml": {Data: []byte("kind: Service")},
+	})
+	if err != nil {
+		t.Fatalf("error loading: %s", err)
+	}
+
+	if err := fsloader.Layer(vfs, fstest.MapFS{
+		"deploy.yaml": {Data: []byte("image: custom")},
+		"extra.yaml":  {Data: []byte("kind: ConfigMap")},
+	}, "base"); err != nil {
+		t.Fatalf("error layering: %s", err)
+	}
+
+	for fpath, expected := range map[string]string{
+		"base/deploy.yaml":  "image: custom",
+		"base/service.yaml": "kind: Service",
+		"base/extra.yaml":   "kind: ConfigMap",
+	} {
+		content, err := vfs.ReadFile(fpath)
+		if err != nil {
+			t.Errorf("error reading %s: %s", fpath, err)
+			continue
+		}
+		if string(content) != expected {
+			t.Errorf("%s: expected %q, got %q", fpath, expected, content)
+		}
+	}
+
+	if err := fsloader.Layer(vfs, fstest.MapFS{}, "base"); err != nil {
+		t.Errorf("error layering empty tree: %s", err)
+	}
+}
@@ -0,0 +1,101 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Destroyer is implemented by MicroControllers capable of removing everything they created.
+type Destroyer interface {
+	Destroy(ctx context.Context) error
+}
+
+// Destroy deletes all objects recorded in the inventory (or applied by this process) together
+// with the inventory config map and the state secret. Objects already gone are ignored. After
+// Destroy the controller is back at NotAppliedOverlay. Requires the controller to have an
+// identity as there is no way of knowing what has been applied otherwise.
+func (k *KustCtrl) Destroy(ctx context.Context) error {
+	if k.name == "" {
+		return fmt.Errorf("controller without identity")
+	}
+
+	inventory, err := k.loadInventory(ctx)
+	if err != nil {
+		return err
+	}
+
+	k.Logger(ctx).Info("destroying component", LogKeyOverlay, k.Overlay())
+	for _, ref := range append(stale(k.appliedRefs(), inventory), inventory...) {
+		if err := k.delete(ctx, ref.Unstructured()); err != nil {
+			return fmt.Errorf("error deleting %s: %w", ref, err)
+		}
+	}
+
+	inv := &corev1.ConfigMap{}
+	inv.Name, inv.Namespace = k.inventoryName(), k.namespace
+	if err := k.delete(ctx, inv); err != nil {
+		return fmt.Errorf("error deleting inventory: %w", err)
+	}
+
+	sct := &corev1.Secret{}
+	sct.Name, sct.Namespace = k.stateName(), k.namespace
+	if err := k.delete(ctx, sct); err != nil {
+		return fmt.Errorf("error deleting state: %w", err)
+	}
+
+	k.setOverlayMetric(NotAppliedOverlay)
+	k.setApplied(NotAppliedOverlay, nil, "")
+	return nil
+}
+
+// delete deletes the object in background, not found errors are ignored.
+func (k *KustCtrl) delete(ctx context.Context, obj client.Object) error {
+	err := k.cli.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Destroy destroys the provided MicroControllers, or all of them if no name is provided, in the
+// reverse dependency order. The state of all MicroControllers implementing Recoverer is recovered
+// first. All destroyed MicroControllers must implement the Destroyer interface.
+func (s *Stack) Destroy(ctx context.Context, names ...string) error {
+	members, err := s.sorted()
+	if err != nil {
+		return fmt.Errorf("invalid stack: %w", err)
+	}
+
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	for i := len(members) - 1; i >= 0; i-- {
+		m := members[i]
+		if len(selected) > 0 && !selected[m.name] {
+			continue
+		}
+
+		dst, ok := m.mctrl.(Destroyer)
+		if !ok {
+			return fmt.Errorf("%q does not support destroy", m.name)
+		}
+
+		if rec, ok := m.mctrl.(Recoverer); ok {
+			if err := rec.Recover(ctx); err != nil {
+				return fmt.Errorf("error recovering %q: %w", m.name, err)
+			}
+		}
+
+		if err := dst.Destroy(ctx); err != nil {
+			return fmt.Errorf("error destroying %q: %w", m.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// ObjectDiff holds the differences between an object as it is in the cluster and as it would be
+// after an overlay is applied. Diff is a unified diff between both yaml representations.
+type ObjectDiff struct {
+	Ref  ObjectRef
+	Diff string
+}
+
+// showSensitiveKey is the context key used to disable the redaction of Secret data on diffs.
+type showSensitiveKey struct{}
+
+// ShowSensitive returns a copy of the context flagged to show sensitive data. Diffs computed
+// with a flagged context print Secret data as is, see IsShowSensitive.
+func ShowSensitive(ctx context.Context) context.Context {
+	return context.WithValue(ctx, showSensitiveKey{}, true)
+}
+
+// IsShowSensitive returns true if the context has been flagged to show sensitive data.
+func IsShowSensitive(ctx context.Context) bool {
+	show, _ := ctx.Value(showSensitiveKey{}).(bool)
+	return show
+}
+
+// Differ is implemented by MicroControllers capable of comparing an overlay with the current
+// cluster state.
+type Differ interface {
+	Diff(ctx context.Context, overlay string, ads *Ads) ([]ObjectDiff, error)
+}
+
+// Diff compares the objects rendered for the provided overlay with their live counterparts. For
+// each rendered object a server side apply dry run is executed and its outcome is compared with
+// the live object, managed fields and status are not taken into account. Secret data is redacted
+// unless the context is flagged through ShowSensitive. Objects that would be pruned are reported
+// as well. Only objects with differences are returned so an empty slice
+// means that applying the overlay would not change anything.
+func (k *KustCtrl) Diff(ctx context.Context, overlay string, ads *Ads) ([]ObjectDiff, error) {
+	objs, err := k.Render(ctx, overlay, ads)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []ObjectDiff
+	for _, obj := range objs {
+		ref := RefFor(obj)
+
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, fmt.Errorf("error converting %s: %w", ref, err)
+		}
+		desired := &unstructured.Unstructured{Object: content}
+
+		if err := k.patch(ctx, desired, client.DryRunAll); err != nil {
+			return nil, fmt.Errorf("error on dry run for %s: %w", ref, err)
+		}
+
+		live, err := k.live(ctx, k.cli, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		diff, err := unifiedDiff(ref, live, desired, !IsShowSensitive(ctx))
+		if err != nil {
+			return nil, err
+		} else if diff == "" {
+			continue
+		}
+		diffs = append(diffs, ObjectDiff{Ref: ref, Diff: diff})
+	}
+
+	toprune, err := k.PruneList(ctx, overlay, ads)
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects to prune: %w", err)
+	}
+
+	for _, ref := range toprune {
+		live, err := k.live(ctx, k.cli, ref)
+		if err != nil {
+			return nil, err
+		} else if live == nil {
+			continue
+		}
+
+		diff, err := unifiedDiff(ref, live, nil, !IsShowSensitive(ctx))
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, ObjectDiff{Ref: ref, Diff: diff})
+	}
+	return diffs, nil
+}
+
+// live reads the referred object through the provided reader. Returns nil if the object does not
+// exist.
+func (k *KustCtrl) live(
+	ctx context.Context, cli client.Reader, ref ObjectRef,
+) (*unstructured.Unstructured, error) {
+	obj := ref.Unstructured()
+	nsn := types.NamespacedName{
+		Namespace: ref.Namespace,
+		Name:      ref.Name,
+	}
+
+	if err := cli.Get(ctx, nsn, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading %s: %w", ref, err)
+	}
+	return obj, nil
+}
+
+// unifiedDiff returns a unified diff between the yaml representation of both objects. Managed
+// fields and status are removed prior to comparison, Secret data is redacted if 'redact' is set.
+// A nil object is represented as an empty document.
+func unifiedDiff(ref ObjectRef, from, to *unstructured.Unstructured, redact bool) (string, error) {
+	if redact {
+		var err error
+		if from, to, err = redactSecrets(from, to); err != nil {
+			return "", fmt.Errorf("error redacting %s: %w", ref, err)
+		}
+	}
+
+	fromdt, err := diffable(from)
+	if err != nil {
+		return "", fmt.Errorf("error processing live %s: %w", ref, err)
+	}
+
+	todt, err := diffable(to)
+	if err != nil {
+		return "", fmt.Errorf("error processing desired %s: %w", ref, err)
+	}
+
+	return difflib.GetUnifiedDiffString(
+		difflib.UnifiedDiff{
+			A:        difflib.SplitLines(fromdt),
+			B:        difflib.SplitLines(todt),
+			FromFile: fmt.Sprintf("live/%s", ref),
+			ToFile:   fmt.Sprintf("desired/%s", ref),
+			Context:  3,
+		},
+	)
+}
+
+// redactSecrets returns copies of both objects with the values under data and stringData of
+// Secrets replaced by Redacted. Values that differ between both objects are suffixed so the diff
+// still reports them as changed. Either object may be nil.
+func redactSecrets(
+	from, to *unstructured.Unstructured,
+) (*unstructured.Unstructured, *unstructured.Unstructured, error) {
+	from, to = copyOrNil(from), copyOrNil(to)
+	for _, field := range []string{"data", "stringData"} {
+		fromvals := secretValues(from, field)
+		tovals := secretValues(to, field)
+		if err := redactValues(from, field, fromvals, tovals, "(live)"); err != nil {
+			return nil, nil, err
+		}
+		if err := redactValues(to, field, tovals, fromvals, "(desired)"); err != nil {
+			return nil, nil, err
+		}
+	}
+	return from, to, nil
+}
+
+// copyOrNil returns a deep copy of the object, nil if the object is nil.
+func copyOrNil(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if obj == nil {
+		return nil
+	}
+	return obj.DeepCopy()
+}
+
+// secretValues returns the values under the provided field if the object is a Secret.
+func secretValues(obj *unstructured.Unstructured, field string) map[string]interface{} {
+	if obj == nil || obj.GetAPIVersion() != "v1" || obj.GetKind() != "Secret" {
+		return nil
+	}
+	vals, _, _ := unstructured.NestedMap(obj.Object, field)
+	return vals
+}
+
+// redactValues replaces, in the object, all values under the provided field by Redacted. Values
+// absent or different in 'other' are suffixed with 'suffix'.
+func redactValues(
+	obj *unstructured.Unstructured, field string, vals, other map[string]interface{}, suffix string,
+) error {
+	if len(vals) == 0 {
+		return nil
+	}
+
+	redacted := map[string]interface{}{}
+	for key, val := range vals {
+		redacted[key] = Redacted
+		if oval, ok := other[key]; !ok || !reflect.DeepEqual(oval, val) {
+			redacted[key] = fmt.Sprintf("%s %s", Redacted, suffix)
+		}
+	}
+	return unstructured.SetNestedMap(obj.Object, redacted, field)
+}
+
+// diffable returns the yaml representation of the object without managed fields and status.
+func diffable(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+
+	obj = obj.DeepCopy()
+	obj.SetManagedFields(nil)
+	unstructured.RemoveNestedField(obj.Object, "status")
+
+	dt, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return string(dt), nil
+}
+
+// Diff compares the current cluster state with the state after all registered MicroControllers
+// are moved to the provided overlay. All MicroControllers must implement both Renderer and Differ
+// interfaces. Ads are passed along as they are during Render. Returns only objects with changes.
+func (s *Stack) Diff(ctx context.Context, overlay string) ([]ObjectDiff, error) {
+	return s.DiffSelected(ctx, overlay, nil)
+}
+
+// DiffSelected works as Diff but only compares the objects of the MicroControllers whose names
+// are present in 'names'. The Ads of all MicroControllers are still rendered so they flow as
+// they would during Apply. An empty 'names' selects all MicroControllers.
+func (s *Stack) DiffSelected(
+	ctx context.Context, overlay string, names []string,
+) ([]ObjectDiff, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	var diffs []ObjectDiff
+	err := s.walkRender(
+		ctx, overlay, func(m *member, rnd Renderer, ads *Ads) error {
+			if len(selected) > 0 && !selected[m.name] {
+				return nil
+			}
+
+			dif, ok := m.mctrl.(Differ)
+			if !ok {
+				return fmt.Errorf("%q does not support diffing", m.name)
+			}
+
+			mdiffs, err := dif.Diff(ctx, overlay, ads)
+			if err != nil {
+				return fmt.Errorf("error diffing %q: %w", m.name, err)
+			}
+			diffs = append(diffs, mdiffs...)
+			return nil
+		},
+	)
+	return diffs, err
+}
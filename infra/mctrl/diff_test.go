@@ -0,0 +1,133 @@
+package mctrl_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+)
+
+func TestStackDiffSelected(t *testing.T) {
+	ctx := context.Background()
+	stack := newWorkloadStack(t, mctrltest.NewHarness("test").Client)
+
+	for _, tt := range []struct {
+		name  string
+		names []string
+		refs  []string
+	}{
+		{
+			name: "all components",
+			refs: []string{"apps/v1/Deployment test/db", "apps/v1/Deployment test/web"},
+		},
+		{
+			name:  "selected components",
+			names: []string{"web"},
+			refs:  []string{"apps/v1/Deployment test/web"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs, err := stack.DiffSelected(ctx, mctrl.BaseOverlay, tt.names)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var refs []string
+			for _, dif := range diffs {
+				refs = append(refs, dif.Ref.String())
+			}
+			if !reflect.DeepEqual(refs, tt.refs) {
+				t.Errorf("expected diffs for %v, got %v", tt.refs, refs)
+			}
+		})
+	}
+}
+
+func TestKustCtrlDiffSecret(t *testing.T) {
+	cli := mctrltest.NewHarness("test").Client
+	live := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "creds"},
+		Data: map[string][]byte{
+			"user":     []byte("admin"),
+			"password": []byte("old-password"),
+		},
+	}
+	if err := cli.Create(context.Background(), live); err != nil {
+		t.Fatalf("error creating secret: %s", err)
+	}
+
+	k := mctrl.NewKustCtrl(
+		cli,
+		fstest.MapFS{
+			"kustomize/base/kustomization.yaml": {Data: []byte("resources:\n- secret.yaml\n")},
+			"kustomize/base/secret.yaml": {
+				Data: []byte(
+					"apiVersion: v1\nkind: Secret\nmetadata:\n  name: creds\n" +
+						"data:\n  user: YWRtaW4=\n  password: bmV3LXBhc3N3b3Jk\n",
+				),
+			},
+		},
+	)
+	k.AddOMutator(func(ctx context.Context, obj client.Object) error {
+		obj.SetNamespace("test")
+		return nil
+	})
+
+	for _, tt := range []struct {
+		name     string
+		ctx      context.Context
+		expected []string
+		hidden   []string
+	}{
+		{
+			name: "redacted",
+			ctx:  context.Background(),
+			expected: []string{
+				"-  password: <redacted> (live)",
+				"+  password: <redacted> (desired)",
+				"   user: <redacted>",
+			},
+			hidden: []string{"YWRtaW4=", "b2xkLXBhc3N3b3Jk", "bmV3LXBhc3N3b3Jk"},
+		},
+		{
+			name: "shown",
+			ctx:  mctrl.ShowSensitive(context.Background()),
+			expected: []string{
+				"-  password: b2xkLXBhc3N3b3Jk",
+				"+  password: bmV3LXBhc3N3b3Jk",
+				"   user: YWRtaW4=",
+			},
+			hidden: []string{mctrl.Redacted},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs, err := k.Diff(tt.ctx, mctrl.BaseOverlay, mctrl.NewAds())
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(diffs) != 1 {
+				t.Fatalf("expected one diff, got %d", len(diffs))
+			}
+
+			for _, line := range tt.expected {
+				if !strings.Contains(diffs[0].Diff, line) {
+					t.Errorf("line %q not found in diff:\n%s", line, diffs[0].Diff)
+				}
+			}
+			for _, val := range tt.hidden {
+				if strings.Contains(diffs[0].Diff, val) {
+					t.Errorf("%q found in diff:\n%s", val, diffs[0].Diff)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package mctrl
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// The following are the reasons of the events emitted by KustCtrl, see WithEventRecorder.
+const (
+	EventApplyStarted   = "ApplyStarted"
+	EventApplied        = "Applied"
+	EventApplyFailed    = "ApplyFailed"
+	EventOverlayChanged = "OverlayChanged"
+	EventReady          = "Ready"
+	EventNotReady       = "NotReady"
+)
+
+// WithEventRecorder makes the controller emit events on the provided owner, usually the object
+// passed to WithOwnerReference in the specialized controllers. Events are emitted when an Apply
+// starts, finishes or fails, when the overlay changes and when the readiness changes. Without a
+// recorder no events are emitted.
+func WithEventRecorder(recorder record.EventRecorder, owner runtime.Object) KustOption {
+	return func(k *KustCtrl) {
+		k.recorder = recorder
+		k.owner = owner
+	}
+}
+
+// Eventf emits an event on the owner, see WithEventRecorder. The event message is prefixed by
+// the controller name so events from different components of a stack can be told apart. This
+// is a no-op if no recorder has been configured.
+func (k *KustCtrl) Eventf(eventtype, reason, format string, args ...interface{}) {
+	if k.recorder == nil || k.owner == nil {
+		return
+	}
+	k.recorder.Eventf(k.owner, eventtype, reason, k.name+": "+format, args...)
+}
+
+// applyEvents emits the events for an Apply call moving the controller from overlay 'from' to
+// overlay 'to' and ending with 'err'.
+func (k *KustCtrl) applyEvents(from, to string, err error) {
+	if err != nil {
+		k.Eventf(corev1.EventTypeWarning, EventApplyFailed, "error applying %s: %s", to, err)
+		return
+	}
+
+	k.Eventf(corev1.EventTypeNormal, EventApplied, "overlay %s applied", to)
+	if from != to {
+		k.Eventf(
+			corev1.EventTypeNormal, EventOverlayChanged, "moved from %q to %q", from, to,
+		)
+	}
+}
+
+// readinessEvent emits an event reporting a readiness transition.
+func (k *KustCtrl) readinessEvent(status *Status) {
+	if status.Ready {
+		k.Eventf(corev1.EventTypeNormal, EventReady, "ready at %s", k.Overlay())
+		return
+	}
+	k.Eventf(corev1.EventTypeNormal, EventNotReady, "not ready: %s", status.Message)
+}
@@ -0,0 +1,284 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// podSpecPaths holds the paths where pod specs may be found in the supported workload kinds.
+var podSpecPaths = [][]string{
+	{"spec"},
+	{"spec", "template", "spec"},
+	{"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// containerFields holds the pod spec fields holding containers.
+var containerFields = []string{"initContainers", "containers"}
+
+// ImageRule maps an image prefix into a different one, e.g. From "quay.io/" To
+// "mirror.local/quay.io/" rewrites "quay.io/org/img:v1" into "mirror.local/quay.io/org/img:v1".
+type ImageRule struct {
+	From string
+	To   string
+}
+
+// ImageRewrite describes a single image reference rewritten by an ImageRewriter.
+type ImageRewrite struct {
+	Ref       ObjectRef
+	Container string
+	From      string
+	To        string
+}
+
+// ImageRewriter rewrites the images of all containers and init containers found in rendered
+// workloads (pods, deployments, stateful sets, jobs, cron jobs, etc). Images are rewritten using
+// the first rule whose From prefix matches. Rewritten images can optionally be pinned to a digest
+// through the digests map, indexed by the rewritten image reference. Use it as an OMutator, see
+// Stack.AddOMutator, and inspect what has been rewritten through Rewrites.
+type ImageRewriter struct {
+	mtx      sync.Mutex
+	rules    []ImageRule
+	digests  map[string]string
+	pin      bool
+	rewrites map[string]ImageRewrite
+}
+
+// NewImageRewriter returns an ImageRewriter using the provided prefix rules and digests. If
+// 'pin' is true every rewritten image not already referring to a digest must have a digest in
+// the digests map, otherwise the rewrite fails.
+func NewImageRewriter(rules []ImageRule, digests map[string]string, pin bool) *ImageRewriter {
+	return &ImageRewriter{
+		rules:    rules,
+		digests:  digests,
+		pin:      pin,
+		rewrites: map[string]ImageRewrite{},
+	}
+}
+
+// Rewrite returns the rewritten version of the provided image reference.
+func (r *ImageRewriter) Rewrite(image string) (string, error) {
+	out := image
+	for _, rule := range r.rules {
+		if strings.HasPrefix(image, rule.From) {
+			out = rule.To + strings.TrimPrefix(image, rule.From)
+			break
+		}
+	}
+
+	if strings.Contains(out, "@") {
+		return out, nil
+	}
+
+	if digest, ok := r.digests[out]; ok {
+		return fmt.Sprintf("%s@%s", stripTag(out), digest), nil
+	}
+
+	if r.pin {
+		return "", fmt.Errorf("no digest for image %q", out)
+	}
+	return out, nil
+}
+
+// stripTag removes the tag from an image reference, if present.
+func stripTag(image string) string {
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		return image[:idx]
+	}
+	return image
+}
+
+// Mutate rewrites the images of all containers found in the object. Objects without pod specs
+// are left untouched. This function has the OMutator signature.
+func (r *ImageRewriter) Mutate(ctx context.Context, obj client.Object) error {
+	ref := RefFor(obj)
+	return visitImages(
+		obj, func(container, image string) (string, error) {
+			out, err := r.Rewrite(image)
+			if err != nil {
+				return "", fmt.Errorf("error rewriting %s: %w", ref, err)
+			}
+
+			if out != image {
+				r.record(ImageRewrite{ref, container, image, out})
+			}
+			return out, nil
+		},
+	)
+}
+
+// record keeps track of a rewrite.
+func (r *ImageRewriter) record(rewrite ImageRewrite) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	key := fmt.Sprintf("%s/%s", rewrite.Ref, rewrite.Container)
+	r.rewrites[key] = rewrite
+}
+
+// Rewrites returns all rewrites done so far, one per object container, sorted by object and
+// container.
+func (r *ImageRewriter) Rewrites() []ImageRewrite {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	keys := make([]string, 0, len(r.rewrites))
+	for key := range r.rewrites {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rewrites := make([]ImageRewrite, 0, len(keys))
+	for _, key := range keys {
+		rewrites = append(rewrites, r.rewrites[key])
+	}
+	return rewrites
+}
+
+// Images returns the sorted list of distinct images used by containers and init containers in
+// the provided objects.
+func Images(objs []client.Object) ([]string, error) {
+	seen := map[string]bool{}
+	for _, obj := range objs {
+		if err := visitImages(
+			obj, func(container, image string) (string, error) {
+				seen[image] = true
+				return image, nil
+			},
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	images := make([]string, 0, len(seen))
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// visitImages calls 'fn' for every container found in the object pod specs, the image returned
+// by 'fn' replaces the container image.
+func visitImages(obj client.Object, fn func(container, image string) (string, error)) error {
+	uobj, isunstructured := obj.(*unstructured.Unstructured)
+
+	var content map[string]interface{}
+	if isunstructured {
+		content = uobj.Object
+	} else {
+		var err error
+		if content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+			return fmt.Errorf("error converting object: %w", err)
+		}
+	}
+
+	changed := false
+	for _, specpath := range podSpecPaths {
+		for _, field := range containerFields {
+			path := append(append([]string{}, specpath...), field)
+			containers, found, _ := unstructured.NestedSlice(content, path...)
+			if !found {
+				continue
+			}
+
+			for _, raw := range containers {
+				container, ok := raw.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				name, _ := container["name"].(string)
+				image, _ := container["image"].(string)
+				out, err := fn(name, image)
+				if err != nil {
+					return err
+				}
+
+				if out != image {
+					container["image"] = out
+					changed = true
+				}
+			}
+
+			if err := unstructured.SetNestedSlice(content, containers, path...); err != nil {
+				return fmt.Errorf("error setting containers: %w", err)
+			}
+		}
+	}
+
+	if !changed || isunstructured {
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(content, obj)
+}
+
+// Images returns the sorted list of distinct images the stack would pull when moved to any of
+// the provided overlays, see ImagesSelected.
+func (s *Stack) Images(ctx context.Context, overlays ...string) ([]string, error) {
+	return s.ImagesSelected(ctx, nil, overlays...)
+}
+
+// ImagesSelected returns the sorted list of distinct images the MicroControllers whose names are
+// present in 'names' would pull when moved to any of the provided overlays. Each MicroController
+// is only rendered at the overlays it provides (see OverlayLister), an overlay provided by none
+// of them is an error. Without overlays every overlay provided by the selected MicroControllers
+// is inspected. An empty 'names' selects all MicroControllers. All MicroControllers must
+// implement the Renderer interface.
+func (s *Stack) ImagesSelected(
+	ctx context.Context, names []string, overlays ...string,
+) ([]string, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	// maps each overlay into the selected members providing it.
+	providers := map[string][]string{}
+	for _, m := range s.members {
+		if len(selected) > 0 && !selected[m.name] {
+			continue
+		}
+
+		lister, ok := m.mctrl.(OverlayLister)
+		if !ok {
+			return nil, fmt.Errorf("%q does not support listing overlays", m.name)
+		}
+
+		moverlays, err := lister.Overlays()
+		if err != nil {
+			return nil, fmt.Errorf("error listing %q overlays: %w", m.name, err)
+		}
+
+		for _, overlay := range moverlays {
+			providers[overlay] = append(providers[overlay], m.name)
+		}
+	}
+
+	if len(overlays) == 0 {
+		for overlay := range providers {
+			overlays = append(overlays, overlay)
+		}
+		sort.Strings(overlays)
+	}
+
+	var objs []client.Object
+	for _, overlay := range overlays {
+		mnames, ok := providers[overlay]
+		if !ok {
+			return nil, fmt.Errorf("overlay %q not provided by any component", overlay)
+		}
+
+		oobjs, err := s.RenderSelected(ctx, overlay, mnames)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering %q: %w", overlay, err)
+		}
+		objs = append(objs, oobjs...)
+	}
+	return Images(objs)
+}
@@ -0,0 +1,328 @@
+package mctrl_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+var mirror = []mctrl.ImageRule{
+	{From: "quay.io/", To: "mirror.local/quay.io/"},
+	{From: "docker.io/", To: "mirror.local/docker.io/"},
+}
+
+func TestImageRewrite(t *testing.T) {
+	digests := map[string]string{
+		"mirror.local/quay.io/projectquay/clair:4.3.0": "sha256:aaa",
+	}
+
+	for _, tt := range []struct {
+		name  string
+		image string
+		pin   bool
+		out   string
+		err   bool
+	}{
+		{
+			name:  "prefix",
+			image: "docker.io/library/redis:6",
+			out:   "mirror.local/docker.io/library/redis:6",
+		},
+		{
+			name:  "no matching rule",
+			image: "gcr.io/project/img:v1",
+			out:   "gcr.io/project/img:v1",
+		},
+		{
+			name:  "digest",
+			image: "quay.io/projectquay/clair:4.3.0",
+			out:   "mirror.local/quay.io/projectquay/clair@sha256:aaa",
+		},
+		{
+			name:  "already pinned",
+			image: "quay.io/projectquay/clair@sha256:bbb",
+			pin:   true,
+			out:   "mirror.local/quay.io/projectquay/clair@sha256:bbb",
+		},
+		{
+			name:  "pin without digest",
+			image: "docker.io/library/redis:6",
+			pin:   true,
+			err:   true,
+		},
+		{
+			name:  "registry with port",
+			image: "localhost:5000/img",
+			out:   "localhost:5000/img",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := mctrl.NewImageRewriter(mirror, digests, tt.pin).Rewrite(tt.image)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, rewritten to %q", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if out != tt.out {
+				t.Errorf("expected %q, got %q", tt.out, out)
+			}
+		})
+	}
+}
+
+func TestImageRewriterMutate(t *testing.T) {
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "clair", Namespace: "test"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{Name: "init", Image: "docker.io/library/busybox:1"},
+					},
+					Containers: []corev1.Container{
+						{Name: "clair", Image: "quay.io/projectquay/clair:4.3.0"},
+						{Name: "sidecar", Image: "gcr.io/project/img:v1"},
+					},
+				},
+			},
+		},
+	}
+
+	cron := &unstructured.Unstructured{}
+	cron.SetAPIVersion("batch/v1")
+	cron.SetKind("CronJob")
+	cron.SetName("backup")
+	cron.SetNamespace("test")
+	if err := unstructured.SetNestedSlice(
+		cron.Object,
+		[]interface{}{
+			map[string]interface{}{"name": "mc", "image": "docker.io/minio/mc:latest"},
+		},
+		"spec", "jobTemplate", "spec", "template", "spec", "containers",
+	); err != nil {
+		t.Fatalf("error building cron job: %s", err)
+	}
+
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "clair", Namespace: "test"}}
+
+	rewriter := mctrl.NewImageRewriter(mirror, nil, false)
+	for _, obj := range []client.Object{dep, cron, svc} {
+		if err := rewriter.Mutate(context.Background(), obj); err != nil {
+			t.Fatalf("error mutating %s: %s", mctrl.RefFor(obj), err)
+		}
+	}
+
+	if img := dep.Spec.Template.Spec.InitContainers[0].Image; img != "mirror.local/docker.io/library/busybox:1" {
+		t.Errorf("init container not rewritten: %q", img)
+	}
+	if img := dep.Spec.Template.Spec.Containers[0].Image; img != "mirror.local/quay.io/projectquay/clair:4.3.0" {
+		t.Errorf("container not rewritten: %q", img)
+	}
+	if img := dep.Spec.Template.Spec.Containers[1].Image; img != "gcr.io/project/img:v1" {
+		t.Errorf("unmatched container rewritten: %q", img)
+	}
+
+	images, err := mctrl.Images([]client.Object{dep, cron, svc})
+	if err != nil {
+		t.Fatalf("error listing images: %s", err)
+	}
+	expected := []string{
+		"gcr.io/project/img:v1",
+		"mirror.local/docker.io/library/busybox:1",
+		"mirror.local/docker.io/minio/mc:latest",
+		"mirror.local/quay.io/projectquay/clair:4.3.0",
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("expected images %v, got %v", expected, images)
+	}
+
+	var got []string
+	for _, rw := range rewriter.Rewrites() {
+		got = append(got, rw.Ref.Name+"/"+rw.Container+": "+rw.From)
+	}
+	expected = []string{
+		"clair/clair: quay.io/projectquay/clair:4.3.0",
+		"clair/init: docker.io/library/busybox:1",
+		"backup/mc: docker.io/minio/mc:latest",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected rewrites %v, got %v", expected, got)
+	}
+}
+
+func TestImageRewriterMutatePin(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "test"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "redis", Image: "docker.io/library/redis:6"}},
+		},
+	}
+
+	rewriter := mctrl.NewImageRewriter(mirror, nil, true)
+	if err := rewriter.Mutate(context.Background(), pod); err == nil {
+		t.Fatal("expected error pinning image without digest")
+	}
+	if img := pod.Spec.Containers[0].Image; img != "docker.io/library/redis:6" {
+		t.Errorf("failed rewrite changed the object: %q", img)
+	}
+	if rewrites := rewriter.Rewrites(); len(rewrites) != 0 {
+		t.Errorf("failed rewrite recorded: %v", rewrites)
+	}
+}
+
+// workload is a MicroController, backed by a KustCtrl, advertising nothing.
+type workload struct {
+	*mctrl.KustCtrl
+}
+
+func (w *workload) Advertise(context.Context) (*mctrl.Ads, error) {
+	return mctrl.NewAds(), nil
+}
+
+func (w *workload) RenderAds(context.Context, string) (*mctrl.Ads, error) {
+	return mctrl.NewAds(), nil
+}
+
+// newWorkload returns a workload whose base holds a deployment running 'image'. Besides the
+// base it provides a scale-down overlay and the provided extra overlays, each extra overlay adds
+// a cron job running the image it maps to.
+func newWorkload(cli client.Client, name, image string, extra map[string]string) *workload {
+	files := fstest.MapFS{
+		"kustomize/base/kustomization.yaml": {Data: []byte("resources:\n- deployment.yaml\n")},
+		"kustomize/base/deployment.yaml": {
+			Data: []byte(fmt.Sprintf(
+				"apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: %s\n"+
+					"spec:\n  template:\n    spec:\n      containers:\n"+
+					"      - name: %s\n        image: %s\n",
+				name, name, image,
+			)),
+		},
+		"kustomize/scale-down/kustomization.yaml": {Data: []byte("resources:\n- ../base\n")},
+	}
+
+	for overlay, img := range extra {
+		files["kustomize/"+overlay+"/kustomization.yaml"] = &fstest.MapFile{
+			Data: []byte("resources:\n- ../base\n- cronjob.yaml\n"),
+		}
+		files["kustomize/"+overlay+"/cronjob.yaml"] = &fstest.MapFile{
+			Data: []byte(fmt.Sprintf(
+				"apiVersion: batch/v1\nkind: CronJob\nmetadata:\n  name: %s-%s\n"+
+					"spec:\n  jobTemplate:\n    spec:\n      template:\n        spec:\n"+
+					"          containers:\n          - name: job\n            image: %s\n",
+				name, overlay, img,
+			)),
+		}
+	}
+
+	k := mctrl.NewKustCtrl(cli, files)
+	k.AddOMutator(func(ctx context.Context, obj client.Object) error {
+		obj.SetNamespace("test")
+		return nil
+	})
+	return &workload{KustCtrl: k}
+}
+
+// newWorkloadStack returns a stack with a database, providing a backup overlay, and a web
+// workload.
+func newWorkloadStack(t *testing.T, cli client.Client) *mctrl.Stack {
+	t.Helper()
+
+	db := newWorkload(cli, "db", "postgres:13", map[string]string{"backup": "minio/mc:latest"})
+	web := newWorkload(cli, "web", "nginx:1", nil)
+
+	stack := mctrl.NewStack()
+	if err := stack.Register("db", db, nil, nil); err != nil {
+		t.Fatalf("error registering db: %s", err)
+	}
+	if err := stack.Register("web", web, nil, nil); err != nil {
+		t.Fatalf("error registering web: %s", err)
+	}
+	return stack
+}
+
+func TestKustCtrlOverlays(t *testing.T) {
+	wl := newWorkload(nil, "db", "postgres:13", map[string]string{"backup": "minio/mc:latest"})
+	mctrl.WithLayer(fstest.MapFS{
+		"kustomize/restore/kustomization.yaml": {Data: []byte("resources:\n- ../base\n")},
+		"kustomize/notes/README.md":            {Data: []byte("not an overlay")},
+	})(wl.KustCtrl)
+
+	overlays, err := wl.Overlays()
+	if err != nil {
+		t.Fatalf("error listing overlays: %s", err)
+	}
+	expected := []string{"backup", mctrl.BaseOverlay, "restore", mctrl.ScaleDownOverlay}
+	if !reflect.DeepEqual(overlays, expected) {
+		t.Errorf("expected overlays %v, got %v", expected, overlays)
+	}
+}
+
+func TestStackImagesSelected(t *testing.T) {
+	ctx := context.Background()
+	stack := newWorkloadStack(t, nil)
+
+	for _, tt := range []struct {
+		name     string
+		names    []string
+		overlays []string
+		images   []string
+		err      bool
+	}{
+		{
+			name:   "all overlays of all components",
+			images: []string{"minio/mc:latest", "nginx:1", "postgres:13"},
+		},
+		{
+			name:   "all overlays of selected components",
+			names:  []string{"web"},
+			images: []string{"nginx:1"},
+		},
+		{
+			name:     "overlay provided by some components",
+			overlays: []string{"backup"},
+			images:   []string{"minio/mc:latest", "postgres:13"},
+		},
+		{
+			name:     "overlay provided by all components",
+			names:    []string{"db", "web"},
+			overlays: []string{mctrl.BaseOverlay},
+			images:   []string{"nginx:1", "postgres:13"},
+		},
+		{
+			name:     "overlay not provided by selected components",
+			names:    []string{"web"},
+			overlays: []string{"backup"},
+			err:      true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			images, err := stack.ImagesSelected(ctx, tt.names, tt.overlays...)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got images %v", images)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(images, tt.images) {
+				t.Errorf("expected images %v, got %v", tt.images, images)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package mctrl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// InventoryKey is the key, inside the inventory config map, where the list of objects owned by
+// a KustCtrl is kept.
+const InventoryKey = "objects"
+
+// PruneAnnotation, when set to PruneDisabled on a rendered object, keeps the object around once it
+// disappears from the rendered overlay. Such objects stay in the inventory so they are still
+// removed by Destroy. Meant for objects holding data that must survive overlay changes, e.g. a
+// claim holding backups.
+const (
+	PruneAnnotation = "freighter.io/prune"
+	PruneDisabled   = "disabled"
+)
+
+// ObjectRef identifies an object in the cluster. This is what a KustCtrl keeps in its inventory
+// in order to know what objects it owns.
+type ObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// RefFor returns an ObjectRef for the provided object. The object must have its TypeMeta
+// populated as this is where the api version and kind are read from.
+func RefFor(obj client.Object) ObjectRef {
+	apiv, kind := obj.GetObjectKind().GroupVersionKind().ToAPIVersionAndKind()
+	return ObjectRef{
+		APIVersion: apiv,
+		Kind:       kind,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
+
+// String returns a human readable representation of the reference.
+func (o ObjectRef) String() string {
+	if o.Namespace == "" {
+		return fmt.Sprintf("%s/%s %s", o.APIVersion, o.Kind, o.Name)
+	}
+	return fmt.Sprintf("%s/%s %s/%s", o.APIVersion, o.Kind, o.Namespace, o.Name)
+}
+
+// Unstructured returns an unstructured object pointing to the referred object. Returned object
+// contains only api version, kind, namespace and name.
+func (o ObjectRef) Unstructured() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(schema.FromAPIVersionAndKind(o.APIVersion, o.Kind))
+	obj.SetNamespace(o.Namespace)
+	obj.SetName(o.Name)
+	return obj
+}
+
+// inventoryName returns the name of the config map used to store the inventory.
+func (k *KustCtrl) inventoryName() string {
+	return fmt.Sprintf("%s-inventory", k.name)
+}
+
+// loadInventory reads the list of objects recorded in the inventory config map. Returns an
+// empty list if the inventory does not exist yet.
+func (k *KustCtrl) loadInventory(ctx context.Context) ([]ObjectRef, error) {
+	nsn := types.NamespacedName{
+		Namespace: k.namespace,
+		Name:      k.inventoryName(),
+	}
+
+	var cm corev1.ConfigMap
+	if err := k.cli.Get(ctx, nsn, &cm); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading inventory: %w", err)
+	}
+
+	var refs []ObjectRef
+	if err := json.Unmarshal([]byte(cm.Data[InventoryKey]), &refs); err != nil {
+		return nil, fmt.Errorf("error parsing inventory: %w", err)
+	}
+	return refs, nil
+}
+
+// storeInventory records provided list of objects in the inventory config map. The config map
+// goes through all registered OMutators before being applied so it ends up with the same owner
+// as all other objects.
+func (k *KustCtrl) storeInventory(ctx context.Context, refs []ObjectRef) error {
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].String() < refs[j].String()
+	})
+
+	dt, err := json.Marshal(refs)
+	if err != nil {
+		return fmt.Errorf("error marshaling inventory: %w", err)
+	}
+
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      k.inventoryName(),
+			Namespace: k.namespace,
+		},
+		Data: map[string]string{
+			InventoryKey: string(dt),
+		},
+	}
+
+	for _, mut := range k.OMutators {
+		if err := mut(ctx, cm); err != nil {
+			return fmt.Errorf("error mutating inventory: %w", err)
+		}
+	}
+
+	if err := k.patch(ctx, cm); err != nil {
+		return fmt.Errorf("error patching inventory: %w", err)
+	}
+	return nil
+}
+
+// stale returns all references present in 'inventory' that are not present in 'current'.
+func stale(inventory, current []ObjectRef) []ObjectRef {
+	seen := map[ObjectRef]bool{}
+	for _, ref := range current {
+		seen[ref] = true
+	}
+
+	var out []ObjectRef
+	for _, ref := range inventory {
+		if !seen[ref] {
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+// prune deletes all objects recorded in the inventory that are not part of 'current' and then
+// records 'current' as the new inventory. If pruning has been disabled nothing is deleted and
+// stale objects are kept in the inventory so they can be pruned later on. The same happens to
+// objects annotated with PruneAnnotation. This is a no-op for controllers without identity.
+func (k *KustCtrl) prune(ctx context.Context, current []ObjectRef) error {
+	if k.name == "" {
+		return nil
+	}
+
+	inventory, err := k.loadInventory(ctx)
+	if err != nil {
+		return err
+	}
+
+	toprune := stale(inventory, current)
+	if !k.prunes {
+		return k.storeInventory(ctx, append(current, toprune...))
+	}
+
+	kept, toprune, err := k.retained(ctx, toprune)
+	if err != nil {
+		return err
+	}
+	current = append(current, kept...)
+
+	for _, ref := range toprune {
+		k.Logger(ctx).Info("pruning object", refValues(ref)...)
+		err := k.cli.Delete(
+			ctx, ref.Unstructured(), client.PropagationPolicy(metav1.DeletePropagationBackground),
+		)
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error pruning %s: %w", ref, err)
+		}
+	}
+	return k.storeInventory(ctx, current)
+}
+
+// recordPartial merges the references of the objects patched before a failed Apply into the
+// inventory so they can still be pruned, or destroyed, later on. Nothing is pruned. The digest
+// of the last applied objects is dropped as they are no longer what the cluster holds. Always
+// returns an error wrapping 'cause'. This is a no-op for controllers without identity.
+func (k *KustCtrl) recordPartial(ctx context.Context, patched []ObjectRef, cause error) error {
+	if k.name == "" || len(patched) == 0 {
+		return cause
+	}
+
+	inventory, err := k.loadInventory(ctx)
+	if err != nil {
+		return fmt.Errorf("%w (error recording applied objects: %s)", cause, err)
+	}
+
+	if err := k.storeInventory(ctx, append(inventory, stale(patched, inventory)...)); err != nil {
+		return fmt.Errorf("%w (error recording applied objects: %s)", cause, err)
+	}
+
+	overlay, _ := k.appliedDigest()
+	k.setApplied(overlay, k.appliedRefs(), "")
+	if err := k.storeState(ctx); err != nil {
+		return fmt.Errorf("%w (error recording applied objects: %s)", cause, err)
+	}
+	return cause
+}
+
+// PruneList returns the list of objects that would be pruned if provided overlay was applied
+// with provided Ads. Nothing is written to the cluster, this is meant to be used as a dry-run.
+// The list is returned even if pruning has been disabled through WithoutPrune.
+func (k *KustCtrl) PruneList(ctx context.Context, overlay string, ads *Ads) ([]ObjectRef, error) {
+	if k.name == "" {
+		return nil, nil
+	}
+
+	objs, err := k.Render(ctx, overlay, ads)
+	if err != nil {
+		return nil, err
+	}
+
+	var current []ObjectRef
+	for _, obj := range objs {
+		current = append(current, RefFor(obj))
+	}
+
+	inventory, err := k.loadInventory(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, toprune, err := k.retained(ctx, stale(inventory, current))
+	return toprune, err
+}
+
+// retained splits the references into the ones whose objects have pruning disabled through the
+// PruneAnnotation and the ones that can be pruned. Objects no longer in the cluster are returned
+// as prunable so they leave the inventory.
+func (k *KustCtrl) retained(
+	ctx context.Context, refs []ObjectRef,
+) (kept, toprune []ObjectRef, err error) {
+	for _, ref := range refs {
+		obj := ref.Unstructured()
+		if err := k.cli.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			if errors.IsNotFound(err) {
+				toprune = append(toprune, ref)
+				continue
+			}
+			return nil, nil, fmt.Errorf("error reading %s: %w", ref, err)
+		}
+
+		if obj.GetAnnotations()[PruneAnnotation] == PruneDisabled {
+			kept = append(kept, ref)
+			continue
+		}
+		toprune = append(toprune, ref)
+	}
+	return kept, toprune, nil
+}
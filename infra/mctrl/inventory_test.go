@@ -0,0 +1,199 @@
+package mctrl_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+	"github.com/ricardomaraschini/freighter/infra/resource"
+)
+
+// kfiles is a kustomize tree with a config map in the base and one overlay per test scenario,
+// each one adding a config map on top of the base.
+var kfiles = fstest.MapFS{
+	"kustomize/base/kustomization.yaml": {Data: []byte("resources:\n- a.yaml\n")},
+	"kustomize/base/a.yaml":             {Data: configMap("a", "")},
+	"kustomize/extra/kustomization.yaml": {
+		Data: []byte("resources:\n- ../base\n- b.yaml\n"),
+	},
+	"kustomize/extra/b.yaml": {Data: configMap("b", "")},
+	"kustomize/retained/kustomization.yaml": {
+		Data: []byte("resources:\n- ../base\n- c.yaml\n"),
+	},
+	"kustomize/retained/c.yaml": {Data: configMap("c", mctrl.PruneDisabled)},
+	"kustomize/broken/kustomization.yaml": {
+		Data: []byte("resources:\n- ../base\n- b.yaml\n- c.yaml\n"),
+	},
+	"kustomize/broken/b.yaml": {Data: configMap("b", "")},
+	"kustomize/broken/c.yaml": {Data: configMap("c", "")},
+}
+
+// configMap returns the manifest for a config map, 'prune' is set as the PruneAnnotation if
+// not empty.
+func configMap(name, prune string) []byte {
+	manifest := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name + "\n"
+	if prune != "" {
+		manifest += "  annotations:\n    " + mctrl.PruneAnnotation + ": " + prune + "\n"
+	}
+	return []byte(manifest + "data:\n  key: rendered\n")
+}
+
+// errPatch is the error returned by failingClient.
+var errPatch = errors.New("patch failed")
+
+// failingClient fails all patches against the object called 'name'.
+type failingClient struct {
+	client.Client
+	name string
+}
+
+// Patch returns errPatch for the object called 'name', other objects are patched.
+func (f *failingClient) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	if obj.GetName() == f.name {
+		return errPatch
+	}
+	return f.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// newKustCtrl returns a KustCtrl for kfiles, identified as test/app, whose objects land in the
+// test namespace.
+func newKustCtrl(cli client.Client, opts ...mctrl.KustOption) *mctrl.KustCtrl {
+	k := mctrl.NewKustCtrl(cli, kfiles, opts...)
+	k.SetIdentity("test", "app")
+	k.AddOMutator(func(ctx context.Context, obj client.Object) error {
+		obj.SetNamespace("test")
+		return nil
+	})
+	return k
+}
+
+// ref returns the reference to the config map called 'name' in the test namespace.
+func ref(name string) mctrl.ObjectRef {
+	return mctrl.ObjectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "test", Name: name}
+}
+
+// assertInventory expects the inventory of the test/app controller to hold the config maps
+// with the provided names, sorted.
+func assertInventory(t *testing.T, cli client.Client, names ...string) {
+	t.Helper()
+
+	var cm corev1.ConfigMap
+	nsn := types.NamespacedName{Namespace: "test", Name: "app-inventory"}
+	if err := cli.Get(context.Background(), nsn, &cm); err != nil {
+		t.Fatalf("error reading inventory: %s", err)
+	}
+
+	var refs []mctrl.ObjectRef
+	if err := json.Unmarshal([]byte(cm.Data[mctrl.InventoryKey]), &refs); err != nil {
+		t.Fatalf("error parsing inventory: %s", err)
+	}
+
+	var expected []mctrl.ObjectRef
+	for _, name := range names {
+		expected = append(expected, ref(name))
+	}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Fatalf("inventory holds %v, expected %v", refs, expected)
+	}
+}
+
+// assertExists expects the config map called 'name' to exist, or not, in the test namespace.
+func assertExists(t *testing.T, cli client.Client, name string, exists bool) {
+	t.Helper()
+
+	var cm corev1.ConfigMap
+	nsn := types.NamespacedName{Namespace: "test", Name: name}
+	err := cli.Get(context.Background(), nsn, &cm)
+	if exists && err != nil {
+		t.Fatalf("error reading config map %s: %s", name, err)
+	}
+	if !exists && !apierrors.IsNotFound(err) {
+		t.Fatalf("expected config map %s to not exist, found %v", name, err)
+	}
+}
+
+// apply applies the overlay expecting no error.
+func apply(t *testing.T, k *mctrl.KustCtrl, overlay string) {
+	t.Helper()
+	if err := k.Apply(context.Background(), overlay, mctrl.NewAds()); err != nil {
+		t.Fatalf("error applying %s: %s", overlay, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	cli := mctrltest.NewClient(resource.Scheme)
+	k := newKustCtrl(cli)
+
+	apply(t, k, "extra")
+	assertInventory(t, cli, "a", "b")
+
+	stale, err := k.PruneList(context.Background(), mctrl.BaseOverlay, mctrl.NewAds())
+	if err != nil {
+		t.Fatalf("error listing stale objects: %s", err)
+	}
+	if expected := []mctrl.ObjectRef{ref("b")}; !reflect.DeepEqual(stale, expected) {
+		t.Fatalf("prune list %v, expected %v", stale, expected)
+	}
+
+	apply(t, k, mctrl.BaseOverlay)
+	assertInventory(t, cli, "a")
+	assertExists(t, cli, "a", true)
+	assertExists(t, cli, "b", false)
+}
+
+func TestPruneRetained(t *testing.T) {
+	cli := mctrltest.NewClient(resource.Scheme)
+	k := newKustCtrl(cli)
+
+	apply(t, k, "retained")
+	apply(t, k, mctrl.BaseOverlay)
+	assertInventory(t, cli, "a", "c")
+	assertExists(t, cli, "c", true)
+
+	stale, err := k.PruneList(context.Background(), mctrl.BaseOverlay, mctrl.NewAds())
+	if err != nil {
+		t.Fatalf("error listing stale objects: %s", err)
+	} else if len(stale) > 0 {
+		t.Fatalf("retained objects listed for pruning: %v", stale)
+	}
+}
+
+func TestWithoutPrune(t *testing.T) {
+	cli := mctrltest.NewClient(resource.Scheme)
+	k := newKustCtrl(cli, mctrl.WithoutPrune())
+
+	apply(t, k, "extra")
+	apply(t, k, mctrl.BaseOverlay)
+	assertInventory(t, cli, "a", "b")
+	assertExists(t, cli, "b", true)
+
+	// once pruning is enabled again stale objects left behind are pruned.
+	apply(t, newKustCtrl(cli), mctrl.BaseOverlay)
+	assertInventory(t, cli, "a")
+	assertExists(t, cli, "b", false)
+}
+
+func TestApplyFailureRecordsPatched(t *testing.T) {
+	cli := mctrltest.NewClient(resource.Scheme)
+	k := newKustCtrl(&failingClient{Client: cli, name: "c"})
+
+	if err := k.Apply(context.Background(), "broken", mctrl.NewAds()); !errors.Is(err, errPatch) {
+		t.Fatalf("expected patch error, found %v", err)
+	}
+	assertInventory(t, cli, "a", "b")
+
+	apply(t, newKustCtrl(cli), mctrl.BaseOverlay)
+	assertExists(t, cli, "b", false)
+}
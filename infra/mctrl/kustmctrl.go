@@ -2,10 +2,18 @@ package mctrl
 
 import (
 	"context"
-	"embed"
 	"fmt"
+	"io/fs"
+	"os"
 	"path"
+	"sync"
+	"time"
 
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/kustomize/api/filesys"
 	"sigs.k8s.io/kustomize/api/krusty"
@@ -40,63 +48,220 @@ const BaseKustomizationPath = "/kustomize/base/kustomization.yaml"
 // treated as an overlay to be applied on base's top. This struct, intentionally, does not fully
 // comply with the MicroController interface, it is a struct to be used as composition to higher
 // specialized constructs.
+//
+// Once an identity is set (see SetIdentity) KustCtrl keeps an inventory of all objects it has
+// applied in a config map. Objects that disappear from the rendered overlay are then pruned. The
+// last applied overlay is kept in a secret, see Recover.
 type KustCtrl struct {
-	cli       client.Client
-	from      embed.FS
-	overlay   string
-	fowner    string
-	KMutators []func(context.Context, *types.Kustomization, Ads) error
-	OMutators []func(context.Context, client.Object) error
-}
-
-// NewKustCtrl returns a kustomize controller reading and applying files provided by the embed.FS
-// reference. Files are read from 'emb' into a filesys.FileSystem representation and then used as
-// argument to Kustomize when generating objects.
-func NewKustCtrl(cli client.Client, emb embed.FS) *KustCtrl {
-	return &KustCtrl{
+	cli           client.Client
+	from          fs.FS
+	layers        []layer
+	mtx           sync.RWMutex
+	overlay       string
+	fowner        string
+	namespace     string
+	name          string
+	prunes        bool
+	transactional bool
+	force         bool
+	resolver      func(context.Context, *ConflictError) bool
+	scheme        *runtime.Scheme
+	applied       []ObjectRef
+	digest        string
+	watch         *statusWatch
+	logger        logr.Logger
+	readiness     readinessLog
+	recorder      record.EventRecorder
+	owner         runtime.Object
+	KMutators     []func(context.Context, *types.Kustomization, *Ads) error
+	OMutators     []func(context.Context, client.Object) error
+}
+
+// KustOption is a function capable of setting an optional parameter in a KustCtrl.
+type KustOption func(*KustCtrl)
+
+// WithoutPrune disables the pruning of objects that are no longer part of the rendered overlay.
+// Stale objects are kept in the inventory so they can still be pruned later on.
+func WithoutPrune() KustOption {
+	return func(k *KustCtrl) {
+		k.prunes = false
+	}
+}
+
+// WithScheme sets the scheme used to resolve the types of the rendered objects. Kinds unknown to
+// the scheme are handled as unstructured objects. Defaults to resource.Scheme.
+func WithScheme(scheme *runtime.Scheme) KustOption {
+	return func(k *KustCtrl) {
+		k.scheme = scheme
+	}
+}
+
+// layer is a fs.FS layered on top of the controller files under the 'at' directory.
+type layer struct {
+	fsys fs.FS
+	at   string
+}
+
+// WithLayer layers the files in provided fs.FS on top of the controller files. The fs.FS must
+// follow the same layout as the controller files, i.e. overlays live under the kustomize
+// directory. Files present in both replace the controller ones. Layers are applied in the order
+// they are provided.
+func WithLayer(fsys fs.FS) KustOption {
+	return func(k *KustCtrl) {
+		k.layers = append(k.layers, layer{fsys: fsys, at: "."})
+	}
+}
+
+// WithLayerDir layers a directory on disk on top of the controller kustomize tree. The directory
+// is mapped to the kustomize directory, e.g. <dir>/base/deployment.yaml replaces the controller
+// base deployment while <dir>/my-overlay/kustomization.yaml adds a new overlay. The directory is
+// read every time the controller renders its objects.
+func WithLayerDir(dir string) KustOption {
+	return func(k *KustCtrl) {
+		k.layers = append(k.layers, layer{fsys: os.DirFS(dir), at: "kustomize"})
+	}
+}
+
+// NewKustCtrl returns a kustomize controller reading and applying files provided by the fs.FS
+// reference (usually an embed.FS). Files are read from 'from' into a filesys.FileSystem
+// representation and then used as argument to Kustomize when generating objects.
+func NewKustCtrl(cli client.Client, from fs.FS, opts ...KustOption) *KustCtrl {
+	k := &KustCtrl{
 		cli:    cli,
-		from:   emb,
-		fowner: "undefined",
+		from:   from,
+		fowner: DefaultFieldManager,
+		prunes: true,
+		scheme: resource.Scheme,
+	}
+
+	for _, opt := range opts {
+		opt(k)
 	}
+	return k
+}
+
+// SetIdentity sets the namespace and the name identifying this controller instance. The name is
+// used when naming objects KustCtrl keeps to track its own state (e.g. the inventory config map
+// is called <name>-inventory). Controllers without identity do not keep an inventory nor persist
+// their state.
+func (k *KustCtrl) SetIdentity(namespace, name string) {
+	k.namespace = namespace
+	k.name = name
+}
+
+// AddOMutator appends an OMutator to the controller.
+func (k *KustCtrl) AddOMutator(fn func(context.Context, client.Object) error) {
+	k.OMutators = append(k.OMutators, fn)
+}
+
+// Namespace returns the namespace set through SetIdentity.
+func (k *KustCtrl) Namespace() string {
+	return k.namespace
+}
+
+// Name returns the name identifying this controller instance, empty if no identity has been set.
+// This is also the value of the InstanceLabel on all objects rendered by the controller.
+func (k *KustCtrl) Name() string {
+	return k.name
 }
 
 // Apply applies provided overlay and creates objects in the kubernetes API using internal client.
-// In case of failures there is no rollback so it is possible that this ends up partially creating
-// the objects (returns at the first failure). Prior to object creation this function feeds all
-// registered OMutators with the objects allowing for last time adjusts.
-func (k *KustCtrl) Apply(ctx context.Context, overlay string, ad Ads) error {
-	objs, err := k.parse(ctx, overlay, ad)
+// Unless in transactional mode (see WithTransaction) there is no rollback in case of failures so
+// it is possible that this ends up partially creating the objects (returns at the first failure),
+// objects created before the failure are still recorded in the inventory. Prior to object
+// creation this function feeds all registered OMutators with the objects allowing for last time
+// adjusts. Once all objects have been applied the ones recorded in the inventory but no longer
+// rendered are pruned. Objects are applied through server side apply, fields managed by someone
+// else result in a ConflictError unless ownership is forced (see WithForceOwnership).
+func (k *KustCtrl) Apply(ctx context.Context, overlay string, ad *Ads) error {
+	k.Eventf(corev1.EventTypeNormal, EventApplyStarted, "applying overlay %s", overlay)
+
+	from, start := k.Overlay(), time.Now()
+	err := k.apply(ctx, overlay, ad)
+	observeDuration(applyDuration, applyErrors, start, err, k.namespace, k.name, overlay)
+	k.applyEvents(from, overlay, err)
+	return err
+}
+
+// apply implements Apply.
+func (k *KustCtrl) apply(ctx context.Context, overlay string, ad *Ads) error {
+	logger := k.Logger(ctx)
+	logger.Info("applying overlay", LogKeyFromOverlay, k.Overlay(), LogKeyOverlay, overlay)
+
+	objs, err := k.render(ctx, overlay, ad)
 	if err != nil {
-		return fmt.Errorf("error parsing kustomize files: %w", err)
+		return err
 	}
 
+	var refs []ObjectRef
 	for _, obj := range objs {
-		for _, mut := range k.OMutators {
-			if err := mut(ctx, obj); err != nil {
-				return fmt.Errorf("error mutating object: %w", err)
-			}
+		refs = append(refs, RefFor(obj))
+	}
+
+	// objects are filled in with the server response when patched, digest them first.
+	dgst, err := digest(objs)
+	if err != nil {
+		return err
+	}
+
+	var snaps map[ObjectRef]*unstructured.Unstructured
+	if k.transactional {
+		if snaps, err = k.snapshot(ctx, refs); err != nil {
+			return fmt.Errorf("error taking snapshot: %w", err)
 		}
+	}
 
-		// XXX clarify on the field owner usage.
-		err := k.cli.Patch(ctx, obj, client.Apply, client.FieldOwner(k.fowner))
-		if err != nil {
-			return fmt.Errorf("error patching object: %w", err)
+	for i, obj := range objs {
+		err := k.patch(ctx, obj)
+		if err == nil {
+			continue
 		}
+
+		err = fmt.Errorf("error patching object: %w", err)
+		if !k.transactional {
+			return k.recordPartial(ctx, refs[:i], err)
+		}
+		return k.rollback(ctx, snaps, refs[:i], err)
 	}
 
-	k.overlay = overlay
-	return nil
+	if err := k.prune(ctx, refs); err != nil {
+		return fmt.Errorf("error pruning objects: %w", err)
+	}
+
+	k.setOverlayMetric(overlay)
+	k.setApplied(overlay, refs, dgst)
+	if err := k.storeState(ctx); err != nil {
+		return fmt.Errorf("error storing state: %w", err)
+	}
+	logger.Info("overlay applied", LogKeyOverlay, overlay)
+	return k.trackKinds(ctx, k.appliedKinds())
 }
 
-// parse reads kustomize files and returns them all parsed as valid client.Object structs. Loads
-// everything from the embed.FS into a filesys.FileSystem instance, mutates the base kustomization
-// and returns the objects as a slice of client.Object.
-func (k *KustCtrl) parse(ctx context.Context, overlay string, ads Ads) ([]client.Object, error) {
+// load reads everything from the fs.FS into a filesys.FileSystem instance and layers all
+// configured layers on top of it.
+func (k *KustCtrl) load() (filesys.FileSystem, error) {
 	virtfs, err := fsloader.Load(k.from)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load overlay: %w", err)
 	}
 
+	for _, lyr := range k.layers {
+		if err := fsloader.Layer(virtfs, lyr.fsys, lyr.at); err != nil {
+			return nil, fmt.Errorf("unable to load layer: %w", err)
+		}
+	}
+	return virtfs, nil
+}
+
+// parse reads kustomize files and returns them all parsed as valid client.Object structs. Loads
+// all files (see load), mutates the base kustomization and returns the objects as a slice of
+// client.Object.
+func (k *KustCtrl) parse(ctx context.Context, overlay string, ads *Ads) ([]client.Object, error) {
+	virtfs, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+
 	if err := k.mutateKustomization(ctx, virtfs, ads); err != nil {
 		return nil, fmt.Errorf("error setting object name prefix: %w", err)
 	}
@@ -110,7 +275,7 @@ func (k *KustCtrl) parse(ctx context.Context, overlay string, ads Ads) ([]client
 
 	var objs []client.Object
 	for _, rsc := range res.Resources() {
-		obj, err := resource.ToObject(rsc)
+		obj, err := resource.ToObjectWithScheme(rsc, k.scheme)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing object: %w", err)
 		}
@@ -121,7 +286,7 @@ func (k *KustCtrl) parse(ctx context.Context, overlay string, ads Ads) ([]client
 
 // mutateKustomization feeds all registered KMutators with the parsed BaseKustomizationPath.
 // After feeding KMutators the output is marshaled and written back to the filesys.FileSystem.
-func (k *KustCtrl) mutateKustomization(ctx context.Context, fs filesys.FileSystem, ads Ads) error {
+func (k *KustCtrl) mutateKustomization(ctx context.Context, fs filesys.FileSystem, ads *Ads) error {
 	if len(k.KMutators) == 0 {
 		return nil
 	}
@@ -136,7 +301,9 @@ func (k *KustCtrl) mutateKustomization(ctx context.Context, fs filesys.FileSyste
 		return fmt.Errorf("error parsing base kustomization: %w", err)
 	}
 
-	for _, mut := range k.KMutators {
+	logger := k.Logger(ctx)
+	for i, mut := range k.KMutators {
+		logger.V(2).Info("running kustomization mutator", LogKeyMutator, i)
 		if err := mut(ctx, &kust, ads); err != nil {
 			return fmt.Errorf("error mutating kustomization: %w", err)
 		}
@@ -153,7 +320,36 @@ func (k *KustCtrl) mutateKustomization(ctx context.Context, fs filesys.FileSyste
 	return nil
 }
 
-// Overlay returns the last applied overlay.
+// Overlay returns the last applied overlay. In a new process this returns NotAppliedOverlay
+// until either Apply or Recover is called.
 func (k *KustCtrl) Overlay() string {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
 	return k.overlay
 }
+
+// setApplied records the overlay, the objects applied and their digest (see UpToDate). The
+// overlay and the list of applied objects are read by Status which, when watching (see
+// WatchStatus), runs on the informers goroutines so they are always accessed while holding the
+// controller lock.
+func (k *KustCtrl) setApplied(overlay string, refs []ObjectRef, digest string) {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+	k.overlay = overlay
+	k.applied = refs
+	k.digest = digest
+}
+
+// appliedDigest returns the last applied overlay and the digest of its objects, see setApplied.
+func (k *KustCtrl) appliedDigest() (string, string) {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
+	return k.overlay, k.digest
+}
+
+// appliedRefs returns a copy of the list of applied objects, see setApplied.
+func (k *KustCtrl) appliedRefs() []ObjectRef {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
+	return append([]ObjectRef(nil), k.applied...)
+}
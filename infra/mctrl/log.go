@@ -0,0 +1,85 @@
+package mctrl
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The following keys are used in all log entries. Sensitive Ads are never logged as they are,
+// see Ads.Redact.
+const (
+	LogKeyNamespace    = "namespace"
+	LogKeyComponent    = "component"
+	LogKeyOverlay      = "overlay"
+	LogKeyFromOverlay  = "fromOverlay"
+	LogKeyGVK          = "gvk"
+	LogKeyName         = "name"
+	LogKeyFieldManager = "fieldManager"
+	LogKeyMutator      = "mutator"
+	LogKeyReady        = "ready"
+	LogKeyMessage      = "message"
+	LogKeyAds          = "ads"
+)
+
+// WithLogger sets the logger used by the controller. A logger present in the context passed to
+// the controller methods (see logr.NewContext) takes precedence over this one so per call values
+// (e.g. the request being reconciled) are kept. Without a logger nothing is logged.
+func WithLogger(logger logr.Logger) KustOption {
+	return func(k *KustCtrl) {
+		k.logger = logger
+	}
+}
+
+// Logger returns the logger for the provided context, see WithLogger. Entries are tagged with
+// the controller namespace and name. Never returns nil.
+func (k *KustCtrl) Logger(ctx context.Context) logr.Logger {
+	logger := logr.FromContext(ctx)
+	if logger == nil {
+		logger = k.logger
+	}
+	if logger == nil {
+		return logr.Discard()
+	}
+	return logger.WithValues(LogKeyNamespace, k.namespace, LogKeyComponent, k.name)
+}
+
+// refValues returns the key value pairs identifying the referred object in log entries.
+func refValues(ref ObjectRef) []interface{} {
+	return []interface{}{
+		LogKeyGVK, schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).String(),
+		LogKeyName, ref.Name,
+	}
+}
+
+// readinessLog keeps the last readiness logged by a controller so only transitions are logged.
+type readinessLog struct {
+	mtx   sync.Mutex
+	ready *bool
+}
+
+// changed records the readiness and returns true if it differs from the previous one.
+func (r *readinessLog) changed(ready bool) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	changed := r.ready == nil || *r.ready != ready
+	r.ready = &ready
+	return changed
+}
+
+// reportStatus logs the status, and emits an event, if its readiness has changed since the last
+// reported status.
+func (k *KustCtrl) reportStatus(ctx context.Context, status *Status) {
+	if !k.readiness.changed(status.Ready) {
+		return
+	}
+	k.Logger(ctx).Info(
+		"status changed",
+		LogKeyOverlay, k.Overlay(),
+		LogKeyReady, status.Ready,
+		LogKeyMessage, status.Message,
+	)
+	k.readinessEvent(status)
+}
@@ -3,7 +3,9 @@ package mctrl
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -25,8 +27,8 @@ const (
 // the service from outside, for example: Ads for a postgres component would contain its 'user',
 // its 'pass' or even a fully DBURI. Components communicate with each other through advertisements.
 type MicroController interface {
-	Apply(ctx context.Context, overlay string, ads Ads) error
-	Advertise(ctx context.Context) (Ads, error)
+	Apply(ctx context.Context, overlay string, ads *Ads) error
+	Advertise(ctx context.Context) (*Ads, error)
 	Status(ctx context.Context) (*Status, error)
 	Overlay() string
 }
@@ -35,11 +37,13 @@ type MicroController interface {
 // component whose current overlay is 'scaled-down' would return Ready as true if no more replicas
 // are running for the given component. It is the responsibility of each component to verify and
 // assert its status. It is important to return Ready as true only when the component has finished
-// its rollout as some other components may depend on it.
+// its rollout as some other components may depend on it. Objects, when filled, holds the
+// readiness of each individual object (see KustCtrl.Status).
 type Status struct {
 	Ready      bool
 	Message    string
 	Conditions []metav1.Condition
+	Objects    []ObjectStatus
 }
 
 // Ads holds advertised data by one or more than one micro controller. Advertisement data should
@@ -49,9 +53,50 @@ type Status struct {
 // controllers we pass in an Advertisement so the controller can identify if all required data is
 // present, e.g. a micro controller for a go application that depends on a postgres database may
 // fail during its Apply due to the absence of postgres access info in received Advertisement.
-// TODO(rmarasch): Make this struct concurrent safe.
+// Ads is safe for concurrent use and keeps track of which provider advertised each index (see
+// Merge). The zero value is an empty Ads ready to use but a nil *Ads is not, neither readers nor
+// writers accept a nil receiver: use NewAds. Ads must not be copied after first use, always pass
+// it around as a pointer.
 type Ads struct {
-	dict map[string]string
+	mtx  sync.RWMutex
+	dict map[string]ad
+}
+
+// ad is a single piece of advertised data together with the provider that advertised it.
+// Sensitive data is redacted when logged, see PutSensitive.
+type ad struct {
+	val       string
+	provider  string
+	sensitive bool
+}
+
+// Redacted is the value logged in place of sensitive advertised data.
+const Redacted = "<redacted>"
+
+// sensitiveHints are substrings that, when found in an index, flag its data as sensitive even if
+// it has not been advertised through PutSensitive (e.g. after being read back from the state).
+var sensitiveHints = []string{"pass", "secret", "token", "credential"}
+
+// NewAds returns an empty Ads.
+func NewAds() *Ads {
+	return &Ads{
+		dict: map[string]ad{},
+	}
+}
+
+// AdsConflictError is returned by Merge when an index is already advertised by a different
+// provider.
+type AdsConflictError struct {
+	Index    string
+	Current  string
+	Incoming string
+}
+
+// Error returns a description of the conflict.
+func (a *AdsConflictError) Error() string {
+	return fmt.Sprintf(
+		"index %q advertised by both %q and %q", a.Index, a.Current, a.Incoming,
+	)
 }
 
 // Contains verifies if the Ads contains all provided indexes. Returns nil if all indexes were
@@ -59,7 +104,7 @@ type Ads struct {
 func (a *Ads) Contains(indexes ...string) error {
 	var missing []string
 	for _, idx := range indexes {
-		if _, ok := a.dict[idx]; ok {
+		if a.has(idx) {
 			continue
 		}
 		missing = append(missing, idx)
@@ -70,27 +115,161 @@ func (a *Ads) Contains(indexes ...string) error {
 	return nil
 }
 
+// has returns true if index 'idx' has been advertised.
+func (a *Ads) has(idx string) bool {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	_, ok := a.dict[idx]
+	return ok
+}
+
 // Get returns the advertised data at index 'idx'. Empty string is returned if the data has not
 // yet been advertised. Try not to advertise empty strings, please.
 func (a *Ads) Get(idx string) string {
-	if a.dict == nil {
-		return ""
-	}
-	return a.dict[idx]
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	return a.dict[idx].val
+}
+
+// Provider returns the provider that advertised index 'idx'. Empty string is returned if the
+// index has not been advertised or if it was advertised through Put.
+func (a *Ads) Provider(idx string) string {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	return a.dict[idx].provider
 }
 
 // Delete removes advertised data at index 'idx'.
 func (a *Ads) Delete(idx string) {
-	if a.dict == nil {
-		return
-	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
 	delete(a.dict, idx)
 }
 
 // Put advertises value 'val' at index 'idx'. Overwrites if 'idx' has already been advertised.
+// Data advertised through Put has no provider, see Merge.
 func (a *Ads) Put(idx, val string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.dict == nil {
+		a.dict = map[string]ad{}
+	}
+	a.dict[idx] = ad{val: val}
+}
+
+// PutSensitive works as Put but flags the data as sensitive, sensitive data is never logged as
+// is, see Redact.
+func (a *Ads) PutSensitive(idx, val string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
 	if a.dict == nil {
-		a.dict = map[string]string{}
+		a.dict = map[string]ad{}
 	}
-	a.dict[idx] = val
+	a.dict[idx] = ad{val: val, sensitive: true}
+}
+
+// IsSensitive returns true if the data at index 'idx' has been advertised through PutSensitive
+// or if the index looks like one holding sensitive data (e.g. a password).
+func (a *Ads) IsSensitive(idx string) bool {
+	a.mtx.RLock()
+	sensitive := a.dict[idx].sensitive
+	a.mtx.RUnlock()
+	if sensitive {
+		return true
+	}
+
+	lower := strings.ToLower(idx)
+	for _, hint := range sensitiveHints {
+		if strings.Contains(lower, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of all advertised data with sensitive values replaced by Redacted. This
+// is what should be used when logging Ads.
+func (a *Ads) Redact() map[string]string {
+	snap := a.Snapshot()
+	for idx := range snap {
+		if a.IsSensitive(idx) {
+			snap[idx] = Redacted
+		}
+	}
+	return snap
+}
+
+// entries returns a copy of all advertised data, sensitivity flags included.
+func (a *Ads) entries() map[string]ad {
+	entries := map[string]ad{}
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	for idx, ad := range a.dict {
+		entries[idx] = ad
+	}
+	return entries
+}
+
+// Keys returns all advertised indexes sorted alphabetically.
+func (a *Ads) Keys() []string {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	keys := make([]string, 0, len(a.dict))
+	for idx := range a.dict {
+		keys = append(keys, idx)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Snapshot returns a copy of all advertised data indexed by index.
+func (a *Ads) Snapshot() map[string]string {
+	snap := map[string]string{}
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	for idx, ad := range a.dict {
+		snap[idx] = ad.val
+	}
+	return snap
+}
+
+// Merge copies all data advertised in 'from' into 'a' recording 'provider' as the provider for
+// all of them. If an index has already been advertised by a different provider an
+// AdsConflictError is returned and nothing is merged. Indexes previously advertised by the same
+// provider (or advertised through Put) are overwritten.
+func (a *Ads) Merge(provider string, from *Ads) error {
+	incoming := from.entries()
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.dict == nil {
+		a.dict = map[string]ad{}
+	}
+
+	var idxs []string
+	for idx := range incoming {
+		idxs = append(idxs, idx)
+	}
+	sort.Strings(idxs)
+
+	for _, idx := range idxs {
+		cur, ok := a.dict[idx]
+		if !ok || cur.provider == "" || cur.provider == provider {
+			continue
+		}
+		return &AdsConflictError{
+			Index:    idx,
+			Current:  cur.provider,
+			Incoming: provider,
+		}
+	}
+
+	for idx, in := range incoming {
+		a.dict[idx] = ad{val: in.val, provider: provider, sensitive: in.sensitive}
+	}
+	return nil
 }
@@ -0,0 +1,66 @@
+package mctrl_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+func TestAdsMerge(t *testing.T) {
+	ads := mctrl.NewAds()
+	ads.Put("manual", "value")
+
+	pg := mctrl.NewAds()
+	pg.Put("db/postgres/dbhost", "db")
+	pg.PutSensitive("db/postgres/dbpass", "secret")
+	if err := ads.Merge("postgres", pg); err != nil {
+		t.Fatalf("error merging: %s", err)
+	}
+	if provider := ads.Provider("db/postgres/dbhost"); provider != "postgres" {
+		t.Fatalf("expected provider postgres, found %q", provider)
+	}
+	if !ads.IsSensitive("db/postgres/dbpass") {
+		t.Fatal("sensitive flag lost during merge")
+	}
+
+	// the same provider overwrites its own indexes.
+	pg.Put("db/postgres/dbhost", "other")
+	if err := ads.Merge("postgres", pg); err != nil {
+		t.Fatalf("error merging from the same provider: %s", err)
+	}
+	if val := ads.Get("db/postgres/dbhost"); val != "other" {
+		t.Fatalf("expected overwritten value, found %q", val)
+	}
+
+	// indexes set through Put have no provider and can be taken over.
+	manual := mctrl.NewAds()
+	manual.Put("manual", "merged")
+	if err := ads.Merge("redis", manual); err != nil {
+		t.Fatalf("error merging over index without provider: %s", err)
+	}
+
+	// a different provider conflicts and nothing is merged.
+	before := ads.Snapshot()
+	conflicting := mctrl.NewAds()
+	conflicting.Put("another", "value")
+	conflicting.Put("db/postgres/dbhost", "clash")
+	err := ads.Merge("clair", conflicting)
+
+	var cerr *mctrl.AdsConflictError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected conflict error, found %v", err)
+	}
+	expected := mctrl.AdsConflictError{
+		Index:    "db/postgres/dbhost",
+		Current:  "postgres",
+		Incoming: "clair",
+	}
+	if *cerr != expected {
+		t.Fatalf("unexpected conflict %+v, expected %+v", *cerr, expected)
+	}
+	if after := ads.Snapshot(); !reflect.DeepEqual(before, after) {
+		t.Fatalf("ads changed by a conflicting merge: %v", ads.Redact())
+	}
+}
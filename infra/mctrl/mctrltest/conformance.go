@@ -0,0 +1,227 @@
+package mctrltest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// Conformance is a suite checking the semantics every MicroController must honor:
+//
+//   - a new instance is at NotAppliedOverlay and refuses to report its status;
+//   - Apply moves the instance to the overlay, applying the same overlay twice is harmless;
+//   - once the workloads settle the instance reports itself ready;
+//   - once ready at BaseOverlay the instance advertises the indexes it provides;
+//   - a new instance recovers the overlay and the Ads (if it implements mctrl.Recoverer);
+//   - every extra overlay can be applied and settles into a ready state;
+//   - Destroy removes everything (if it implements mctrl.Destroyer).
+//
+// Workloads are driven through Harness.Settle.
+type Conformance struct {
+	// Harness the MicroControllers run against.
+	Harness *Harness
+	// New returns a new instance of the MicroController under test. It is called more than
+	// once and all instances must refer to the same component (same namespace and name).
+	New func(cli client.Client) mctrl.MicroController
+	// Ads are the Ads passed to every Apply call.
+	Ads *mctrl.Ads
+	// Provides lists the indexes the MicroController advertises at BaseOverlay.
+	Provides []string
+	// Overlays lists the overlays, besides BaseOverlay, to be exercised. After each one of
+	// them the MicroController is moved back to BaseOverlay.
+	Overlays []string
+}
+
+// Run runs the suite. Checks depend on each other so the suite stops at the first failure.
+func (c *Conformance) Run(t *testing.T) {
+	ctx := context.Background()
+	if c.Ads == nil {
+		c.Ads = mctrl.NewAds()
+	}
+
+	mc := c.New(c.Harness.Client)
+	var ads *mctrl.Ads
+
+	steps := []struct {
+		name string
+		fn   func(t *testing.T)
+	}{
+		{
+			name: "NotApplied",
+			fn: func(t *testing.T) {
+				if overlay := mc.Overlay(); overlay != mctrl.NotAppliedOverlay {
+					t.Fatalf("new instance at overlay %q", overlay)
+				}
+				if _, err := mc.Status(ctx); err == nil {
+					t.Fatal("status reported before any overlay has been applied")
+				}
+			},
+		},
+		{
+			name: "Apply",
+			fn: func(t *testing.T) {
+				c.applyReady(ctx, t, mc, mctrl.BaseOverlay)
+			},
+		},
+		{
+			name: "ApplyTwice",
+			fn: func(t *testing.T) {
+				c.applyReady(ctx, t, mc, mctrl.BaseOverlay)
+			},
+		},
+		{
+			name: "Advertise",
+			fn: func(t *testing.T) {
+				var err error
+				if ads, err = mc.Advertise(ctx); err != nil {
+					t.Fatalf("error advertising: %s", err)
+				}
+				if err := ads.Contains(c.Provides...); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "Recover",
+			fn: func(t *testing.T) {
+				c.recover(ctx, t, mctrl.BaseOverlay, ads)
+			},
+		},
+		{
+			name: "Overlays",
+			fn: func(t *testing.T) {
+				for _, overlay := range c.Overlays {
+					c.applyReady(ctx, t, mc, overlay)
+					c.recover(ctx, t, overlay, nil)
+					c.applyReady(ctx, t, mc, mctrl.BaseOverlay)
+				}
+			},
+		},
+		{
+			name: "Destroy",
+			fn: func(t *testing.T) {
+				c.destroy(ctx, t, mc)
+			},
+		},
+	}
+
+	for _, step := range steps {
+		if !t.Run(step.name, step.fn) {
+			return
+		}
+	}
+}
+
+// applyReady applies the overlay, settles the workloads and expects the MicroController to
+// report itself ready at the overlay.
+func (c *Conformance) applyReady(
+	ctx context.Context, t *testing.T, mc mctrl.MicroController, overlay string,
+) {
+	t.Helper()
+
+	if err := mc.Apply(ctx, overlay, c.Ads); err != nil {
+		t.Fatalf("error applying %s: %s", overlay, err)
+	}
+	if got := mc.Overlay(); got != overlay {
+		t.Fatalf("expected overlay %q, found %q", overlay, got)
+	}
+
+	if err := c.Harness.Settle(ctx); err != nil {
+		t.Fatalf("error settling workloads: %s", err)
+	}
+
+	status, err := mc.Status(ctx)
+	if err != nil {
+		t.Fatalf("error reading status at %s: %s", overlay, err)
+	}
+	if !status.Ready {
+		t.Fatalf("not ready at %s: %s", overlay, status.Message)
+	}
+}
+
+// recover creates a new instance and, if it implements mctrl.Recoverer, expects it to recover
+// the overlay and, if provided, the Ads.
+func (c *Conformance) recover(
+	ctx context.Context, t *testing.T, overlay string, ads *mctrl.Ads,
+) {
+	t.Helper()
+
+	mc := c.New(c.Harness.Client)
+	rec, ok := mc.(mctrl.Recoverer)
+	if !ok {
+		return
+	}
+
+	if err := rec.Recover(ctx); err != nil {
+		t.Fatalf("error recovering: %s", err)
+	}
+	if got := mc.Overlay(); got != overlay {
+		t.Fatalf("recovered overlay %q, expected %q", got, overlay)
+	}
+	if ads == nil {
+		return
+	}
+
+	recovered, err := mc.Advertise(ctx)
+	if err != nil {
+		t.Fatalf("error advertising after recover: %s", err)
+	}
+	if !reflect.DeepEqual(recovered.Snapshot(), ads.Snapshot()) {
+		t.Fatalf("recovered ads differ: %v, expected %v", recovered.Redact(), ads.Redact())
+	}
+}
+
+// destroy destroys the MicroController, if it implements mctrl.Destroyer, and expects nothing
+// labeled as belonging to it to remain.
+func (c *Conformance) destroy(ctx context.Context, t *testing.T, mc mctrl.MicroController) {
+	t.Helper()
+
+	des, ok := mc.(mctrl.Destroyer)
+	if !ok {
+		return
+	}
+
+	if err := des.Destroy(ctx); err != nil {
+		t.Fatalf("error destroying: %s", err)
+	}
+	if got := mc.Overlay(); got != mctrl.NotAppliedOverlay {
+		t.Fatalf("destroyed instance at overlay %q", got)
+	}
+
+	id, ok := mc.(mctrl.Identified)
+	if !ok {
+		return
+	}
+
+	lists := []client.ObjectList{
+		&appsv1.DeploymentList{},
+		&corev1.ServiceList{},
+		&corev1.PersistentVolumeClaimList{},
+		&corev1.ConfigMapList{},
+		&corev1.SecretList{},
+	}
+	for _, list := range lists {
+		if err := c.Harness.Client.List(
+			ctx, list,
+			client.InNamespace(id.Namespace()),
+			client.MatchingLabels{mctrl.InstanceLabel: id.Name()},
+		); err != nil {
+			t.Fatalf("error listing objects: %s", err)
+		}
+
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			t.Fatalf("error extracting list items: %s", err)
+		}
+		if len(items) > 0 {
+			t.Fatalf("%d objects left in %T after destroy", len(items), list)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package mctrltest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// UpdateGoldenEnv is the environment variable that, when set to a non empty value, makes golden
+// assertions rewrite the golden files instead of comparing against them, e.g.
+//
+//	UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "UPDATE_GOLDEN"
+
+// AssertGolden compares the objects, marshaled as a multi document yaml, with the content of the
+// golden file at 'path'. The test fails, reporting a unified diff, if they differ. Golden files
+// are (re)written when UpdateGoldenEnv is set.
+func AssertGolden(t testing.TB, path string, objs []client.Object) {
+	t.Helper()
+
+	got, err := MarshalObjects(objs)
+	if err != nil {
+		t.Fatalf("error marshaling objects: %s", err)
+	}
+
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("error creating golden file directory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("error writing golden file: %s", err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading golden file (set %s to create it): %s", UpdateGoldenEnv, err)
+	}
+
+	if bytes.Equal(got, want) {
+		return
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(
+		difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(want)),
+			B:        difflib.SplitLines(string(got)),
+			FromFile: path,
+			ToFile:   "rendered",
+			Context:  3,
+		},
+	)
+	if err != nil {
+		t.Fatalf("error computing diff: %s", err)
+	}
+	t.Errorf("rendered objects differ from golden file:\n%s", diff)
+}
+
+// AssertGoldenOverlay renders the overlay through the Renderer, with the provided Ads, and
+// compares the outcome with the golden file, see AssertGolden.
+func AssertGoldenOverlay(
+	t testing.TB, rnd mctrl.Renderer, overlay string, ads *mctrl.Ads, path string,
+) {
+	t.Helper()
+
+	objs, err := rnd.Render(context.Background(), overlay, ads)
+	if err != nil {
+		t.Fatalf("error rendering %s: %s", overlay, err)
+	}
+	AssertGolden(t, path, objs)
+}
+
+// MarshalObjects marshals the objects into a multi document yaml, this is the format used in
+// golden files.
+func MarshalObjects(objs []client.Object) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, obj := range objs {
+		dt, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling %s: %w", mctrl.RefFor(obj), err)
+		}
+		buf.WriteString("---\n")
+		buf.Write(dt)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,278 @@
+// Package mctrltest provides tooling for testing MicroControllers without a cluster. It offers a
+// fake client emulating the bits of the API server MicroControllers rely on (server side apply,
+// secret string data), helpers to drive workloads into a rolled out or terminating state, golden
+// file assertions for rendered overlays and a conformance suite any MicroController should pass.
+// Where workloads must progress on their own, e.g. in envtest runs or while waiting for readiness,
+// a Simulator acts as the deployment controller and the kubelet.
+package mctrltest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/resource"
+)
+
+// Harness bundles a fake client and the namespace MicroControllers under test live in. The
+// zero value is not usable, see NewHarness.
+type Harness struct {
+	Client    client.Client
+	Namespace string
+}
+
+// NewHarness returns a Harness whose client is pre populated with the provided objects. Types
+// are resolved through resource.Scheme, see NewClient.
+func NewHarness(namespace string, objs ...client.Object) *Harness {
+	return &Harness{
+		Client:    NewClient(resource.Scheme, objs...),
+		Namespace: namespace,
+	}
+}
+
+// NewClient returns a fake client pre populated with the provided objects. On top of the
+// controller-runtime fake client it emulates server side apply (objects are created or merged,
+// field ownership is not tracked) and moves secrets string data into their data as the API
+// server would.
+func NewClient(scheme *runtime.Scheme, objs ...client.Object) client.Client {
+	for _, obj := range objs {
+		stringDataToData(obj)
+	}
+
+	return &applyClient{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+	}
+}
+
+// applyClient wraps a fake client adding support for server side apply patches.
+type applyClient struct {
+	client.Client
+}
+
+// Create moves secrets string data into data before creating the object.
+func (a *applyClient) Create(
+	ctx context.Context, obj client.Object, opts ...client.CreateOption,
+) error {
+	stringDataToData(obj)
+	return a.Client.Create(ctx, obj, opts...)
+}
+
+// Update moves secrets string data into data before updating the object.
+func (a *applyClient) Update(
+	ctx context.Context, obj client.Object, opts ...client.UpdateOption,
+) error {
+	stringDataToData(obj)
+	return a.Client.Update(ctx, obj, opts...)
+}
+
+// Patch emulates server side apply patches: the object is created if it does not exist or
+// merged (json merge patch) into the existing one otherwise. Other patch types are passed
+// through. Dry runs are honored, force and field manager options are ignored.
+func (a *applyClient) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	if patch.Type() != types.ApplyPatchType {
+		return a.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	popts := &client.PatchOptions{}
+	popts.ApplyOptions(opts)
+	if len(popts.DryRun) > 0 {
+		return nil
+	}
+
+	stringDataToData(obj)
+	dt, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("error marshaling object: %w", err)
+	}
+
+	err = a.Client.Patch(ctx, obj, client.RawPatch(types.MergePatchType, dt))
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	return a.Client.Create(ctx, obj)
+}
+
+// stringDataToData moves the string data of a secret into its data, other objects are left
+// untouched.
+func stringDataToData(obj client.Object) {
+	sct, ok := obj.(*corev1.Secret)
+	if !ok || len(sct.StringData) == 0 {
+		return
+	}
+
+	if sct.Data == nil {
+		sct.Data = map[string][]byte{}
+	}
+	for key, val := range sct.StringData {
+		sct.Data[key] = []byte(val)
+	}
+	sct.StringData = nil
+}
+
+// Settle drives all workloads in the harness namespace to their desired state as the cluster
+// controllers would: deployments requesting replicas are rolled out (see RollOut), deployments
+// scaled down to zero have their pods terminated and removed (see Terminate and Reap), claims
+// are bound (see BindClaims) and jobs are completed.
+func (h *Harness) Settle(ctx context.Context) error {
+	var deps appsv1.DeploymentList
+	if err := h.Client.List(ctx, &deps, client.InNamespace(h.Namespace)); err != nil {
+		return fmt.Errorf("error listing deployments: %w", err)
+	}
+
+	for i := range deps.Items {
+		dep := &deps.Items[i]
+		if dep.Spec.Replicas == nil || *dep.Spec.Replicas > 0 {
+			if err := RollOut(ctx, h.Client, dep); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := Terminate(ctx, h.Client, dep); err != nil {
+			return err
+		}
+		if err := Reap(ctx, h.Client, h.Namespace); err != nil {
+			return err
+		}
+	}
+
+	if err := BindClaims(ctx, h.Client, h.Namespace); err != nil {
+		return err
+	}
+	return CompleteJobs(ctx, h.Client, h.Namespace)
+}
+
+// BindClaims moves the persistent volume claims in the namespace to the bound phase honoring
+// the binding mode of their storage class (see mctrl.ClaimBindingMode). Claims are bound right
+// away unless their class binds on WaitForFirstConsumer, these are only bound once a pod uses
+// them: the claim is then flagged with the mctrl.SelectedNodeAnnotation, as the scheduler would,
+// and bound. Claims without a consumer stay pending.
+func BindClaims(ctx context.Context, cli client.Client, namespace string) error {
+	var pvcs corev1.PersistentVolumeClaimList
+	if err := cli.List(ctx, &pvcs, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("error listing claims: %w", err)
+	}
+
+	consumers, err := claimConsumers(ctx, cli, namespace)
+	if err != nil {
+		return err
+	}
+
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if pvc.Status.Phase == corev1.ClaimBound {
+			continue
+		}
+
+		mode, err := mctrl.ClaimBindingMode(ctx, cli, pvc.Spec.StorageClassName)
+		if err != nil {
+			return err
+		}
+
+		_, selected := pvc.Annotations[mctrl.SelectedNodeAnnotation]
+		if mode == storagev1.VolumeBindingWaitForFirstConsumer && !selected {
+			node, ok := consumers[types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Name}]
+			if !ok {
+				continue
+			}
+
+			metav1.SetMetaDataAnnotation(&pvc.ObjectMeta, mctrl.SelectedNodeAnnotation, node)
+			if err := cli.Update(ctx, pvc); err != nil {
+				return fmt.Errorf("error selecting node for claim %s: %w", pvc.Name, err)
+			}
+		}
+
+		pvc.Status.Phase = corev1.ClaimBound
+		if err := cli.Status().Update(ctx, pvc); err != nil {
+			return fmt.Errorf("error binding claim %s: %w", pvc.Name, err)
+		}
+	}
+	return nil
+}
+
+// claimConsumers returns the claims used by pods in the namespace, pods being deleted are not
+// considered. Claims are mapped into the node the consuming pod runs on, pods not yet scheduled
+// count as running on the SimulatedNode.
+func claimConsumers(
+	ctx context.Context, cli client.Client, namespace string,
+) (map[types.NamespacedName]string, error) {
+	var pods corev1.PodList
+	if err := cli.List(ctx, &pods, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("error listing pods: %w", err)
+	}
+
+	consumers := map[types.NamespacedName]string{}
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		node := pod.Spec.NodeName
+		if node == "" {
+			node = SimulatedNode
+		}
+		for _, vol := range pod.Spec.Volumes {
+			if vol.PersistentVolumeClaim == nil {
+				continue
+			}
+			nsn := types.NamespacedName{
+				Namespace: pod.Namespace,
+				Name:      vol.PersistentVolumeClaim.ClaimName,
+			}
+			consumers[nsn] = node
+		}
+	}
+	return consumers, nil
+}
+
+// CompleteJobs flags all jobs in the namespace as complete.
+func CompleteJobs(ctx context.Context, cli client.Client, namespace string) error {
+	return CompleteJobsIf(ctx, cli, namespace, func(client.Object) bool { return true })
+}
+
+// CompleteJobsIf flags as complete the jobs in the namespace for which 'pred' returns true.
+func CompleteJobsIf(
+	ctx context.Context, cli client.Client, namespace string, pred func(client.Object) bool,
+) error {
+	var jobs batchv1.JobList
+	if err := cli.List(ctx, &jobs, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("error listing jobs: %w", err)
+	}
+
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Status.CompletionTime != nil || !pred(job) {
+			continue
+		}
+
+		now := metav1.Now()
+		job.Status.StartTime = &now
+		job.Status.CompletionTime = &now
+		job.Status.Succeeded = 1
+		job.Status.Conditions = []batchv1.JobCondition{
+			{
+				Type:               batchv1.JobComplete,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: now,
+			},
+		}
+		if err := cli.Status().Update(ctx, job); err != nil {
+			return fmt.Errorf("error completing job %s: %w", job.Name, err)
+		}
+	}
+	return nil
+}
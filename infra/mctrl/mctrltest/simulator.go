@@ -0,0 +1,451 @@
+package mctrltest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// SimulatedNode is the node name assigned to all pods created by the Simulator.
+const SimulatedNode = "mctrltest-node"
+
+// Simulator plays the role of the deployment and replica set controllers, of the kubelet and of
+// the garbage collector in environments without them, e.g. envtest or the fake client. On every
+// step deployments get a replica set for their current pod template, replica sets get their pods,
+// pods become ready after a delay and pods being deleted go away after another delay. Claims are
+// bound and jobs are completed. Statuses are kept up to date along the way so MicroControllers
+// can be driven end to end as if running against a real cluster. Pods are created with the
+// TerminatingFinalizer so their termination can be delayed.
+type Simulator struct {
+	cli        client.Client
+	namespace  string
+	interval   time.Duration
+	readyAfter time.Duration
+	termAfter  time.Duration
+	mtx        sync.Mutex
+	seen       map[types.NamespacedName]time.Time
+}
+
+// SimulatorOption is a function capable of setting an optional parameter in a Simulator.
+type SimulatorOption func(*Simulator)
+
+// WithSimNamespace restricts the Simulator to a namespace. By default all namespaces are
+// simulated.
+func WithSimNamespace(namespace string) SimulatorOption {
+	return func(s *Simulator) {
+		s.namespace = namespace
+	}
+}
+
+// WithSimInterval sets the interval between two steps when running, defaults to 100ms.
+func WithSimInterval(interval time.Duration) SimulatorOption {
+	return func(s *Simulator) {
+		s.interval = interval
+	}
+}
+
+// WithReadyDelay sets the time pods, and jobs, take to become ready (complete for jobs) after
+// the Simulator has first seen them. Defaults to zero, i.e. ready on the next step.
+func WithReadyDelay(delay time.Duration) SimulatorOption {
+	return func(s *Simulator) {
+		s.readyAfter = delay
+	}
+}
+
+// WithTerminationDelay sets the time pods take to go away after being deleted. Defaults to zero,
+// i.e. gone on the next step.
+func WithTerminationDelay(delay time.Duration) SimulatorOption {
+	return func(s *Simulator) {
+		s.termAfter = delay
+	}
+}
+
+// NewSimulator returns a Simulator acting through the provided client.
+func NewSimulator(cli client.Client, opts ...SimulatorOption) *Simulator {
+	s := &Simulator{
+		cli:      cli,
+		interval: 100 * time.Millisecond,
+		seen:     map[types.NamespacedName]time.Time{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Start runs the Simulator in background until the context is done. Errors are reported to
+// 'onErr', if provided, and do not stop the Simulator.
+func (s *Simulator) Start(ctx context.Context, onErr func(error)) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.Step(ctx); err != nil && ctx.Err() == nil && onErr != nil {
+				onErr(err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Step runs a single simulation pass over all deployments, replica sets, pods, claims and jobs.
+func (s *Simulator) Step(ctx context.Context) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	steps := []func(context.Context) error{
+		s.collectGarbage,
+		s.syncDeployments,
+		s.syncReplicaSets,
+		s.runPods,
+		s.syncStatuses,
+		s.completeJobs,
+	}
+	for _, step := range steps {
+		if err := step(ctx); err != nil {
+			return err
+		}
+	}
+	return BindClaims(ctx, s.cli, s.namespace)
+}
+
+// elapsed records the first time the object has been seen and returns true if at least 'delay'
+// has elapsed since then.
+func (s *Simulator) elapsed(obj client.Object, delay time.Duration) bool {
+	nsn := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	first, ok := s.seen[nsn]
+	if !ok {
+		first = time.Now()
+		s.seen[nsn] = first
+	}
+	return time.Since(first) >= delay
+}
+
+// forget drops the first seen record of the object.
+func (s *Simulator) forget(obj client.Object) {
+	delete(s.seen, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()})
+}
+
+// collectGarbage deletes replica sets whose deployment is gone and pods whose replica set is
+// gone, as the garbage collector would.
+func (s *Simulator) collectGarbage(ctx context.Context) error {
+	var deps appsv1.DeploymentList
+	if err := s.cli.List(ctx, &deps, client.InNamespace(s.namespace)); err != nil {
+		return fmt.Errorf("error listing deployments: %w", err)
+	}
+
+	var rss appsv1.ReplicaSetList
+	if err := s.cli.List(ctx, &rss, client.InNamespace(s.namespace)); err != nil {
+		return fmt.Errorf("error listing replica sets: %w", err)
+	}
+
+	owners := map[types.NamespacedName]bool{}
+	for _, dep := range deps.Items {
+		owners[types.NamespacedName{Namespace: dep.Namespace, Name: "Deployment/" + dep.Name}] = true
+	}
+	for i := range rss.Items {
+		rs := &rss.Items[i]
+		if !orphan(rs, "Deployment", owners) {
+			owners[types.NamespacedName{Namespace: rs.Namespace, Name: "ReplicaSet/" + rs.Name}] = true
+			continue
+		}
+		if err := s.cli.Delete(ctx, rs); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error collecting replica set %s: %w", rs.Name, err)
+		}
+	}
+
+	var pods corev1.PodList
+	if err := s.cli.List(ctx, &pods, client.InNamespace(s.namespace)); err != nil {
+		return fmt.Errorf("error listing pods: %w", err)
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || !orphan(pod, "ReplicaSet", owners) {
+			continue
+		}
+		if err := s.cli.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error collecting pod %s: %w", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// orphan returns true if the object is controlled by an owner of the provided kind that is not
+// present in 'owners'. Objects without a controller of such kind are never orphans.
+func orphan(obj client.Object, kind string, owners map[types.NamespacedName]bool) bool {
+	oref := metav1.GetControllerOf(obj)
+	if oref == nil || oref.Kind != kind {
+		return false
+	}
+	return !owners[types.NamespacedName{Namespace: obj.GetNamespace(), Name: kind + "/" + oref.Name}]
+}
+
+// syncDeployments makes sure every deployment has a replica set for its current pod template
+// with the desired number of replicas, previous replica sets are scaled down to zero.
+func (s *Simulator) syncDeployments(ctx context.Context) error {
+	var deps appsv1.DeploymentList
+	if err := s.cli.List(ctx, &deps, client.InNamespace(s.namespace)); err != nil {
+		return fmt.Errorf("error listing deployments: %w", err)
+	}
+
+	for i := range deps.Items {
+		dep := &deps.Items[i]
+		var want int32 = 1
+		if dep.Spec.Replicas != nil {
+			want = *dep.Spec.Replicas
+		}
+		if _, err := ensureReplicaSet(ctx, s.cli, dep, want); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncReplicaSets creates missing pods, not yet ready, and deletes exceeding pods for every
+// replica set.
+func (s *Simulator) syncReplicaSets(ctx context.Context) error {
+	var rss appsv1.ReplicaSetList
+	if err := s.cli.List(ctx, &rss, client.InNamespace(s.namespace)); err != nil {
+		return fmt.Errorf("error listing replica sets: %w", err)
+	}
+
+	for i := range rss.Items {
+		rs := &rss.Items[i]
+		var want int32 = 1
+		if rs.Spec.Replicas != nil {
+			want = *rs.Spec.Replicas
+		}
+
+		pods, err := s.podsOf(ctx, rs)
+		if err != nil {
+			return err
+		}
+
+		var alive []*corev1.Pod
+		for j := range pods {
+			if pods[j].DeletionTimestamp == nil {
+				alive = append(alive, &pods[j])
+			}
+		}
+
+		for j := int32(len(alive)); j < want; j++ {
+			pod, err := newPod(s.cli, rs)
+			if err != nil {
+				return err
+			}
+			pod.Spec.NodeName = SimulatedNode
+			controllerutil.AddFinalizer(pod, TerminatingFinalizer)
+			if err := s.cli.Create(ctx, pod); err != nil {
+				return fmt.Errorf("error creating pod: %w", err)
+			}
+		}
+
+		for j := int(want); j < len(alive); j++ {
+			if err := s.cli.Delete(ctx, alive[j]); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("error deleting pod %s: %w", alive[j].Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runPods acts as the kubelet: pods become running and ready once the ready delay has elapsed
+// and pods being deleted are removed once the termination delay has elapsed, see removePod.
+func (s *Simulator) runPods(ctx context.Context) error {
+	var pods corev1.PodList
+	if err := s.cli.List(ctx, &pods, client.InNamespace(s.namespace)); err != nil {
+		return fmt.Errorf("error listing pods: %w", err)
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil {
+			if time.Since(pod.DeletionTimestamp.Time) < s.termAfter {
+				continue
+			}
+
+			if err := removePod(ctx, s.cli, pod); err != nil {
+				return fmt.Errorf("error removing pod %s: %w", pod.Name, err)
+			}
+			s.forget(pod)
+			continue
+		}
+
+		if podIsReady(pod) || !s.elapsed(pod, s.readyAfter) {
+			continue
+		}
+
+		MarkPodReady(pod)
+		if err := s.cli.Status().Update(ctx, pod); err != nil {
+			return fmt.Errorf("error updating pod %s status: %w", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// syncStatuses updates replica sets and deployments statuses according to their pods.
+func (s *Simulator) syncStatuses(ctx context.Context) error {
+	var rss appsv1.ReplicaSetList
+	if err := s.cli.List(ctx, &rss, client.InNamespace(s.namespace)); err != nil {
+		return fmt.Errorf("error listing replica sets: %w", err)
+	}
+
+	// counts holds the number of alive and ready pods per replica set.
+	counts := map[types.NamespacedName][2]int32{}
+	for i := range rss.Items {
+		rs := &rss.Items[i]
+		pods, err := s.podsOf(ctx, rs)
+		if err != nil {
+			return err
+		}
+
+		var alive, ready int32
+		for j := range pods {
+			if pods[j].DeletionTimestamp != nil {
+				continue
+			}
+			alive++
+			if podIsReady(&pods[j]) {
+				ready++
+			}
+		}
+		counts[types.NamespacedName{Namespace: rs.Namespace, Name: rs.Name}] = [2]int32{alive, ready}
+
+		rs.Status = appsv1.ReplicaSetStatus{
+			Replicas:             alive,
+			FullyLabeledReplicas: alive,
+			ReadyReplicas:        ready,
+			AvailableReplicas:    ready,
+			ObservedGeneration:   rs.Generation,
+		}
+		if err := s.cli.Status().Update(ctx, rs); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error updating replica set %s status: %w", rs.Name, err)
+		}
+	}
+
+	var deps appsv1.DeploymentList
+	if err := s.cli.List(ctx, &deps, client.InNamespace(s.namespace)); err != nil {
+		return fmt.Errorf("error listing deployments: %w", err)
+	}
+
+	for i := range deps.Items {
+		dep := &deps.Items[i]
+		hash, err := templateHash(dep.Spec.Template)
+		if err != nil {
+			return err
+		}
+
+		owned, err := replicaSetsFor(ctx, s.cli, dep)
+		if err != nil {
+			return err
+		}
+
+		status := appsv1.DeploymentStatus{ObservedGeneration: dep.Generation}
+		for _, rs := range owned {
+			count := counts[types.NamespacedName{Namespace: rs.Namespace, Name: rs.Name}]
+			status.Replicas += count[0]
+			status.ReadyReplicas += count[1]
+			status.AvailableReplicas += count[1]
+			if rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey] == hash {
+				status.UpdatedReplicas = count[0]
+			}
+		}
+		status.Conditions = deploymentConditions(dep, status)
+
+		dep.Status = status
+		if err := s.cli.Status().Update(ctx, dep); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error updating deployment %s status: %w", dep.Name, err)
+		}
+	}
+	return nil
+}
+
+// deploymentConditions returns the Available condition for the deployment status, the last
+// transition time is kept if the condition status has not changed.
+func deploymentConditions(
+	dep *appsv1.Deployment, status appsv1.DeploymentStatus,
+) []appsv1.DeploymentCondition {
+	var want int32 = 1
+	if dep.Spec.Replicas != nil {
+		want = *dep.Spec.Replicas
+	}
+
+	now := metav1.Now()
+	cond := appsv1.DeploymentCondition{
+		Type:               appsv1.DeploymentAvailable,
+		Status:             corev1.ConditionFalse,
+		Reason:             "MinimumReplicasUnavailable",
+		Message:            "Deployment does not have minimum availability.",
+		LastUpdateTime:     now,
+		LastTransitionTime: now,
+	}
+	if status.AvailableReplicas >= want {
+		cond.Status = corev1.ConditionTrue
+		cond.Reason = "MinimumReplicasAvailable"
+		cond.Message = "Deployment has minimum availability."
+	}
+
+	for _, prev := range dep.Status.Conditions {
+		if prev.Type == cond.Type && prev.Status == cond.Status {
+			cond.LastTransitionTime = prev.LastTransitionTime
+		}
+	}
+	return []appsv1.DeploymentCondition{cond}
+}
+
+// completeJobs completes all jobs once the ready delay has elapsed since they were first seen.
+func (s *Simulator) completeJobs(ctx context.Context) error {
+	if s.readyAfter == 0 {
+		return CompleteJobs(ctx, s.cli, s.namespace)
+	}
+	return CompleteJobsIf(ctx, s.cli, s.namespace, func(obj client.Object) bool {
+		return s.elapsed(obj, s.readyAfter)
+	})
+}
+
+// podsOf returns all pods controlled by the replica set.
+func (s *Simulator) podsOf(ctx context.Context, rs *appsv1.ReplicaSet) ([]corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := s.cli.List(ctx, &pods, client.InNamespace(rs.Namespace)); err != nil {
+		return nil, fmt.Errorf("error listing pods: %w", err)
+	}
+
+	var owned []corev1.Pod
+	for _, pod := range pods.Items {
+		oref := metav1.GetControllerOf(&pod)
+		if oref != nil && oref.Kind == "ReplicaSet" && oref.Name == rs.Name {
+			owned = append(owned, pod)
+		}
+	}
+	return owned, nil
+}
+
+// podIsReady returns true if the pod is running and its Ready condition is true.
+func podIsReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
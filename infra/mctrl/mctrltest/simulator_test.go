@@ -0,0 +1,178 @@
+package mctrltest_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+	fresource "github.com/ricardomaraschini/freighter/infra/resource"
+)
+
+// TestSimulatorEnvtest drives a deployment using a claim, whose class binds on the first
+// consumer, through a rollout and a scale down against a real API server. Requires the envtest
+// binaries, see KUBEBUILDER_ASSETS in sigs.k8s.io/controller-runtime/pkg/envtest.
+func TestSimulatorEnvtest(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS not set")
+	}
+
+	env := &envtest.Environment{}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("error starting envtest: %s", err)
+	}
+	defer func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("error stopping envtest: %s", err)
+		}
+	}()
+
+	cli, err := client.New(cfg, client.Options{Scheme: fresource.Scheme})
+	if err != nil {
+		t.Fatalf("error creating client: %s", err)
+	}
+
+	ctx := context.Background()
+	namespace := "simulator"
+	mode := storagev1.VolumeBindingWaitForFirstConsumer
+	replicas := int32(2)
+	labels := map[string]string{"app": "simulated"}
+	objs := []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}},
+		&storagev1.StorageClass{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "local",
+				Annotations: map[string]string{
+					mctrl.DefaultClassAnnotation: "true",
+				},
+			},
+			Provisioner:       "kubernetes.io/no-provisioner",
+			VolumeBindingMode: &mode,
+		},
+		claim(namespace, "data"),
+		claim(namespace, "unused"),
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "app"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{
+							{Name: "app", Image: "app:latest"},
+						},
+						Volumes: []corev1.Volume{
+							{
+								Name: "data",
+								VolumeSource: corev1.VolumeSource{
+									PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+										ClaimName: "data",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, obj := range objs {
+		if err := cli.Create(ctx, obj); err != nil {
+			t.Fatalf("error creating %s: %s", obj.GetName(), err)
+		}
+	}
+
+	sim := mctrltest.NewSimulator(cli, mctrltest.WithSimNamespace(namespace))
+	depnsn := types.NamespacedName{Namespace: namespace, Name: "app"}
+
+	// step runs the simulator until 'cond' is met. Errors are logged and do not stop the
+	// simulation as conflicts are expected against a real API server.
+	step := func(what string, cond func() (bool, error)) {
+		t.Helper()
+		err := wait.PollImmediate(100*time.Millisecond, 30*time.Second, func() (bool, error) {
+			if err := sim.Step(ctx); err != nil {
+				t.Logf("error running simulator: %s", err)
+			}
+			return cond()
+		})
+		if err != nil {
+			t.Fatalf("error waiting for %s: %s", what, err)
+		}
+	}
+
+	step("rollout", func() (bool, error) {
+		var dep appsv1.Deployment
+		if err := cli.Get(ctx, depnsn, &dep); err != nil {
+			return false, err
+		}
+		return dep.Status.AvailableReplicas == replicas, nil
+	})
+
+	var data corev1.PersistentVolumeClaim
+	nsn := types.NamespacedName{Namespace: namespace, Name: "data"}
+	if err := cli.Get(ctx, nsn, &data); err != nil {
+		t.Fatalf("error reading claim: %s", err)
+	}
+	if data.Status.Phase != corev1.ClaimBound {
+		t.Fatalf("consumed claim not bound: %s", data.Status.Phase)
+	}
+	if node := data.Annotations[mctrl.SelectedNodeAnnotation]; node != mctrltest.SimulatedNode {
+		t.Fatalf("consumed claim selected node %q", node)
+	}
+
+	var unused corev1.PersistentVolumeClaim
+	nsn = types.NamespacedName{Namespace: namespace, Name: "unused"}
+	if err := cli.Get(ctx, nsn, &unused); err != nil {
+		t.Fatalf("error reading claim: %s", err)
+	}
+	if unused.Status.Phase == corev1.ClaimBound {
+		t.Fatal("claim without consumer bound")
+	}
+
+	var dep appsv1.Deployment
+	if err := cli.Get(ctx, depnsn, &dep); err != nil {
+		t.Fatalf("error reading deployment: %s", err)
+	}
+	zero := int32(0)
+	dep.Spec.Replicas = &zero
+	if err := cli.Update(ctx, &dep); err != nil {
+		t.Fatalf("error scaling deployment down: %s", err)
+	}
+
+	step("pods removal", func() (bool, error) {
+		var pods corev1.PodList
+		if err := cli.List(ctx, &pods, client.InNamespace(namespace)); err != nil {
+			return false, err
+		}
+		return len(pods.Items) == 0, nil
+	})
+}
+
+// claim returns a claim, without storage class, in the namespace.
+func claim(namespace, name string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse("1Gi"),
+				},
+			},
+		},
+	}
+}
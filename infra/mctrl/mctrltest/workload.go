@@ -0,0 +1,420 @@
+package mctrltest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// TerminatingFinalizer is the finalizer Terminate adds to pods so they stay around, flagged for
+// deletion, until Reap is called.
+const TerminatingFinalizer = "mctrltest.freighter.io/terminating"
+
+// RollOut drives a deployment to the rolled out state as the deployment and replica set
+// controllers, and the kubelet, would: a replica set for the current pod template is created
+// with the requested number of running and ready pods, pods from previous templates are removed
+// and the deployment status is updated to report all replicas updated and available.
+func RollOut(ctx context.Context, cli client.Client, dep *appsv1.Deployment) error {
+	var want int32 = 1
+	if dep.Spec.Replicas != nil {
+		want = *dep.Spec.Replicas
+	}
+
+	rs, err := ensureReplicaSet(ctx, cli, dep, want)
+	if err != nil {
+		return err
+	}
+
+	pods, err := podsFor(ctx, cli, dep)
+	if err != nil {
+		return err
+	}
+
+	hash := rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+	var current int32
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		if pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey] == hash && current < want {
+			current++
+			continue
+		}
+
+		if err := cli.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error deleting pod %s: %w", pod.Name, err)
+		}
+	}
+
+	for i := current; i < want; i++ {
+		if err := createPod(ctx, cli, rs); err != nil {
+			return err
+		}
+	}
+
+	rs.Status = appsv1.ReplicaSetStatus{
+		Replicas:             want,
+		FullyLabeledReplicas: want,
+		ReadyReplicas:        want,
+		AvailableReplicas:    want,
+		ObservedGeneration:   rs.Generation,
+	}
+	if err := cli.Status().Update(ctx, rs); err != nil {
+		return fmt.Errorf("error updating replica set status: %w", err)
+	}
+
+	now := metav1.Now()
+	dep.Status = appsv1.DeploymentStatus{
+		ObservedGeneration: dep.Generation,
+		Replicas:           want,
+		UpdatedReplicas:    want,
+		ReadyReplicas:      want,
+		AvailableReplicas:  want,
+		Conditions: []appsv1.DeploymentCondition{
+			{
+				Type:               appsv1.DeploymentAvailable,
+				Status:             corev1.ConditionTrue,
+				Reason:             "MinimumReplicasAvailable",
+				Message:            "Deployment has minimum availability.",
+				LastUpdateTime:     now,
+				LastTransitionTime: now,
+			},
+			{
+				Type:               appsv1.DeploymentProgressing,
+				Status:             corev1.ConditionTrue,
+				Reason:             "NewReplicaSetAvailable",
+				Message:            fmt.Sprintf("ReplicaSet %q has successfully progressed.", rs.Name),
+				LastUpdateTime:     now,
+				LastTransitionTime: now,
+			},
+		},
+	}
+	if err := cli.Status().Update(ctx, dep); err != nil {
+		return fmt.Errorf("error updating deployment status: %w", err)
+	}
+	return nil
+}
+
+// Terminate drives a deployment into the terminating state: all its pods are flagged for
+// deletion but kept around (see TerminatingFinalizer) and the deployment, and its replica sets,
+// report no replicas. Pods go away once Reap is called.
+func Terminate(ctx context.Context, cli client.Client, dep *appsv1.Deployment) error {
+	pods, err := podsFor(ctx, cli, dep)
+	if err != nil {
+		return err
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		if !controllerutil.ContainsFinalizer(pod, TerminatingFinalizer) {
+			controllerutil.AddFinalizer(pod, TerminatingFinalizer)
+			if err := cli.Update(ctx, pod); err != nil {
+				return fmt.Errorf("error updating pod %s: %w", pod.Name, err)
+			}
+		}
+
+		if err := cli.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error deleting pod %s: %w", pod.Name, err)
+		}
+	}
+
+	rss, err := replicaSetsFor(ctx, cli, dep)
+	if err != nil {
+		return err
+	}
+
+	for i := range rss {
+		rs := &rss[i]
+		rs.Status = appsv1.ReplicaSetStatus{ObservedGeneration: rs.Generation}
+		if err := cli.Status().Update(ctx, rs); err != nil {
+			return fmt.Errorf("error updating replica set status: %w", err)
+		}
+	}
+
+	dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation}
+	if err := cli.Status().Update(ctx, dep); err != nil {
+		return fmt.Errorf("error updating deployment status: %w", err)
+	}
+	return nil
+}
+
+// Reap removes all terminating pods in the namespace, i.e. pods flagged for deletion by
+// Terminate, see removePod.
+func Reap(ctx context.Context, cli client.Client, namespace string) error {
+	var pods corev1.PodList
+	if err := cli.List(ctx, &pods, client.InNamespace(namespace)); err != nil {
+		return fmt.Errorf("error listing pods: %w", err)
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp == nil {
+			continue
+		}
+
+		if err := removePod(ctx, cli, pod); err != nil {
+			return fmt.Errorf("error reaping pod %s: %w", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// removePod removes the TerminatingFinalizer from a pod flagged for deletion and deletes it with
+// no grace period, as the kubelet does once all containers are gone. Without the kubelet an API
+// server keeps pods bound to a node around until their grace period is forced to zero.
+func removePod(ctx context.Context, cli client.Client, pod *corev1.Pod) error {
+	controllerutil.RemoveFinalizer(pod, TerminatingFinalizer)
+	if err := cli.Update(ctx, pod); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	err := cli.Delete(ctx, pod, client.GracePeriodSeconds(0))
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// ensureReplicaSet creates, or updates, the replica set for the current deployment pod template.
+// Replica sets for previous templates are scaled down to zero.
+func ensureReplicaSet(
+	ctx context.Context, cli client.Client, dep *appsv1.Deployment, replicas int32,
+) (*appsv1.ReplicaSet, error) {
+	hash, err := templateHash(dep.Spec.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	rss, err := replicaSetsFor(ctx, cli, dep)
+	if err != nil {
+		return nil, err
+	}
+
+	var current *appsv1.ReplicaSet
+	for i := range rss {
+		rs := &rss[i]
+		if rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey] == hash {
+			current = rs
+			continue
+		}
+
+		var zero int32
+		if rs.Spec.Replicas != nil && *rs.Spec.Replicas == zero {
+			continue
+		}
+		rs.Spec.Replicas = &zero
+		if err := cli.Update(ctx, rs); err != nil {
+			return nil, fmt.Errorf("error scaling down replica set %s: %w", rs.Name, err)
+		}
+	}
+
+	if current != nil {
+		if current.Spec.Replicas != nil && *current.Spec.Replicas == replicas {
+			return current, nil
+		}
+		current.Spec.Replicas = &replicas
+		if err := cli.Update(ctx, current); err != nil {
+			return nil, fmt.Errorf("error scaling replica set %s: %w", current.Name, err)
+		}
+		return current, nil
+	}
+
+	lbls := map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: hash}
+	for key, val := range dep.Spec.Template.Labels {
+		lbls[key] = val
+	}
+
+	template := *dep.Spec.Template.DeepCopy()
+	template.Labels = lbls
+
+	selector := dep.Spec.Selector.DeepCopy()
+	if selector == nil {
+		selector = &metav1.LabelSelector{}
+	}
+	if selector.MatchLabels == nil {
+		selector.MatchLabels = map[string]string{}
+	}
+	selector.MatchLabels[appsv1.DefaultDeploymentUniqueLabelKey] = hash
+
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", dep.Name, hash),
+			Namespace: dep.Namespace,
+			Labels:    lbls,
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Replicas: &replicas,
+			Selector: selector,
+			Template: template,
+		},
+	}
+	if err := controllerutil.SetControllerReference(dep, rs, cli.Scheme()); err != nil {
+		return nil, fmt.Errorf("error setting replica set owner: %w", err)
+	}
+
+	if err := cli.Create(ctx, rs); err != nil {
+		return nil, fmt.Errorf("error creating replica set: %w", err)
+	}
+	return rs, nil
+}
+
+// createPod creates a running and ready pod for the replica set.
+func createPod(ctx context.Context, cli client.Client, rs *appsv1.ReplicaSet) error {
+	pod, err := newPod(cli, rs)
+	if err != nil {
+		return err
+	}
+
+	if err := cli.Create(ctx, pod); err != nil {
+		return fmt.Errorf("error creating pod: %w", err)
+	}
+
+	MarkPodReady(pod)
+	if err := cli.Status().Update(ctx, pod); err != nil {
+		return fmt.Errorf("error updating pod status: %w", err)
+	}
+	return nil
+}
+
+// newPod returns a pod for the replica set, as the replica set controller would create it.
+// Nothing is written to the cluster.
+func newPod(cli client.Client, rs *appsv1.ReplicaSet) (*corev1.Pod, error) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			// the fake client does not generate names.
+			Name:        fmt.Sprintf("%s-%s", rs.Name, rand.String(5)),
+			Namespace:   rs.Namespace,
+			Labels:      rs.Spec.Template.Labels,
+			Annotations: rs.Spec.Template.Annotations,
+		},
+		Spec: rs.Spec.Template.Spec,
+	}
+	if err := controllerutil.SetControllerReference(rs, pod, cli.Scheme()); err != nil {
+		return nil, fmt.Errorf("error setting pod owner: %w", err)
+	}
+	return pod, nil
+}
+
+// MarkPodReady sets the pod status to running with all containers ready, nothing is written
+// to the cluster.
+func MarkPodReady(pod *corev1.Pod) {
+	now := metav1.Now()
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.StartTime = &now
+	pod.Status.Conditions = nil
+	for _, ctype := range []corev1.PodConditionType{
+		corev1.PodScheduled, corev1.PodInitialized, corev1.ContainersReady, corev1.PodReady,
+	} {
+		pod.Status.Conditions = append(
+			pod.Status.Conditions,
+			corev1.PodCondition{
+				Type:               ctype,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: now,
+			},
+		)
+	}
+
+	pod.Status.ContainerStatuses = nil
+	for _, cont := range pod.Spec.Containers {
+		pod.Status.ContainerStatuses = append(
+			pod.Status.ContainerStatuses,
+			corev1.ContainerStatus{
+				Name:    cont.Name,
+				Image:   cont.Image,
+				Ready:   true,
+				Started: boolPtr(true),
+				State: corev1.ContainerState{
+					Running: &corev1.ContainerStateRunning{StartedAt: now},
+				},
+			},
+		)
+	}
+}
+
+// podsFor returns all pods matching the deployment selector.
+func podsFor(
+	ctx context.Context, cli client.Client, dep *appsv1.Deployment,
+) ([]corev1.Pod, error) {
+	sel, err := selectorFor(dep)
+	if err != nil {
+		return nil, err
+	}
+
+	var pods corev1.PodList
+	if err := cli.List(
+		ctx, &pods,
+		client.InNamespace(dep.Namespace),
+		client.MatchingLabelsSelector{Selector: sel},
+	); err != nil {
+		return nil, fmt.Errorf("error listing pods: %w", err)
+	}
+	return pods.Items, nil
+}
+
+// replicaSetsFor returns all replica sets controlled by the deployment.
+func replicaSetsFor(
+	ctx context.Context, cli client.Client, dep *appsv1.Deployment,
+) ([]appsv1.ReplicaSet, error) {
+	var rss appsv1.ReplicaSetList
+	if err := cli.List(ctx, &rss, client.InNamespace(dep.Namespace)); err != nil {
+		return nil, fmt.Errorf("error listing replica sets: %w", err)
+	}
+
+	var owned []appsv1.ReplicaSet
+	for _, rs := range rss.Items {
+		if oref := metav1.GetControllerOf(&rs); oref != nil && oref.Name == dep.Name {
+			owned = append(owned, rs)
+		}
+	}
+	return owned, nil
+}
+
+// selectorFor returns the deployment selector, pods without labels are never matched.
+func selectorFor(dep *appsv1.Deployment) (labels.Selector, error) {
+	if dep.Spec.Selector == nil {
+		return labels.Nothing(), nil
+	}
+
+	sel, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deployment selector: %w", err)
+	}
+	return sel, nil
+}
+
+// templateHash returns a short hash of the pod template, used to tell replica sets apart.
+func templateHash(template corev1.PodTemplateSpec) (string, error) {
+	dt, err := json.Marshal(template)
+	if err != nil {
+		return "", fmt.Errorf("error hashing pod template: %w", err)
+	}
+
+	hasher := fnv.New32a()
+	hasher.Write(dt)
+	return fmt.Sprintf("%08x", hasher.Sum32()), nil
+}
+
+// boolPtr returns a pointer to the provided bool.
+func boolPtr(b bool) *bool {
+	return &b
+}
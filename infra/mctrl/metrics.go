@@ -0,0 +1,125 @@
+package mctrl
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// The following are the prometheus metrics exported by this package. They are registered in the
+// controller-runtime registry (metrics.Registry) so in operator mode they are served together
+// with the controller-runtime metrics. Components are identified by their namespace and name,
+// see KustCtrl.SetIdentity.
+var (
+	renderDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "freighter_render_duration_seconds",
+			Help: "Time spent rendering an overlay.",
+		},
+		[]string{"namespace", "component", "overlay"},
+	)
+
+	renderErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "freighter_render_errors_total",
+			Help: "Number of failed overlay renders.",
+		},
+		[]string{"namespace", "component", "overlay"},
+	)
+
+	applyDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "freighter_apply_duration_seconds",
+			Help:    "Time spent applying an overlay, readiness is not included.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+		},
+		[]string{"namespace", "component", "overlay"},
+	)
+
+	applyErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "freighter_apply_errors_total",
+			Help: "Number of failed overlay applies.",
+		},
+		[]string{"namespace", "component", "overlay"},
+	)
+
+	timeToReady = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "freighter_time_to_ready_seconds",
+			Help:    "Time between the end of an apply and the component reporting ready.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		},
+		[]string{"namespace", "component", "overlay"},
+	)
+
+	currentOverlay = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "freighter_overlay",
+			Help: "Overlay a component is currently at, the value is always 1.",
+		},
+		[]string{"namespace", "component", "overlay"},
+	)
+
+	ssaConflicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "freighter_ssa_conflicts_total",
+			Help: "Number of server side apply conflicts, per conflicting field manager.",
+		},
+		[]string{"namespace", "component", "manager"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		renderDuration,
+		renderErrors,
+		applyDuration,
+		applyErrors,
+		timeToReady,
+		currentOverlay,
+		ssaConflicts,
+	)
+}
+
+// Identified is implemented by MicroControllers with an identity, e.g. the ones embedding
+// KustCtrl. Stack uses it to label the metrics it collects.
+type Identified interface {
+	Namespace() string
+	Name() string
+}
+
+// observeDuration records the time elapsed since 'start' in the histogram. If 'err' is not nil
+// the counter is increased instead.
+func observeDuration(
+	hist *prometheus.HistogramVec, errs *prometheus.CounterVec, start time.Time, err error,
+	labels ...string,
+) {
+	if err != nil {
+		errs.WithLabelValues(labels...).Inc()
+		return
+	}
+	hist.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
+
+// setOverlayMetric moves the overlay gauge of the controller from its previous overlay to the
+// provided one. An empty overlay removes the gauge.
+func (k *KustCtrl) setOverlayMetric(overlay string) {
+	if k.name == "" {
+		return
+	}
+	if from := k.Overlay(); from != "" {
+		currentOverlay.DeleteLabelValues(k.namespace, k.name, from)
+	}
+	if overlay != "" {
+		currentOverlay.WithLabelValues(k.namespace, k.name, overlay).Set(1)
+	}
+}
+
+// countConflicts increases the conflict counter once per conflicting field manager.
+func (k *KustCtrl) countConflicts(cerr *ConflictError) {
+	for _, manager := range cerr.Managers() {
+		ssaConflicts.WithLabelValues(k.namespace, k.name, manager).Inc()
+	}
+}
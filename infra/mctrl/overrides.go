@@ -0,0 +1,141 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/yaml"
+)
+
+// WithImage replaces the image 'name' (tag-less, as found in the manifests) by the provided
+// image reference. The reference may carry a tag or a digest, e.g. quay.io/org/img:v1 or
+// quay.io/org/img@sha256:<hash>. Relies on the kustomize images field.
+func WithImage(name, image string) KustOption {
+	return func(k *KustCtrl) {
+		k.KMutators = append(
+			k.KMutators,
+			func(ctx context.Context, kust *types.Kustomization, ads *Ads) error {
+				kust.Images = append(kust.Images, ImageOverride(name, image))
+				return nil
+			},
+		)
+	}
+}
+
+// ImageOverride returns a kustomize image entry replacing the image 'name' by the provided image
+// reference.
+func ImageOverride(name, image string) types.Image {
+	img := types.Image{Name: name}
+	if idx := strings.Index(image, "@"); idx >= 0 {
+		img.NewName = image[:idx]
+		img.Digest = image[idx+1:]
+		return img
+	}
+
+	img.NewName = image
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		img.NewName = image[:idx]
+		img.NewTag = image[idx+1:]
+	}
+	return img
+}
+
+// WithReplicas sets the number of replicas for the workload called 'name' (as found in the
+// manifests, without the name prefix). Relies on the kustomize replicas field so teardown
+// overlays (e.g. ScaleDownOverlay) still take precedence.
+func WithReplicas(name string, count int64) KustOption {
+	return func(k *KustCtrl) {
+		k.KMutators = append(
+			k.KMutators,
+			func(ctx context.Context, kust *types.Kustomization, ads *Ads) error {
+				kust.Replicas = append(
+					kust.Replicas,
+					types.Replica{
+						Name:  name,
+						Count: count,
+					},
+				)
+				return nil
+			},
+		)
+	}
+}
+
+// WithPatches adds the provided patches to the base kustomization. Patches can be either
+// strategic merge or JSON6902 patches, see kustomize patches field.
+func WithPatches(patches ...types.Patch) KustOption {
+	return func(k *KustCtrl) {
+		k.KMutators = append(
+			k.KMutators,
+			func(ctx context.Context, kust *types.Kustomization, ads *Ads) error {
+				kust.Patches = append(kust.Patches, patches...)
+				return nil
+			},
+		)
+	}
+}
+
+// WithResources merges the resource requests and limits into a container in the pod template of
+// the object of the provided kind and name (as found in the manifests). Implemented through a
+// strategic merge patch, requests and limits not present in 'res' are kept.
+func WithResources(
+	kind, name, container string, res corev1.ResourceRequirements,
+) KustOption {
+	return func(k *KustCtrl) {
+		k.KMutators = append(
+			k.KMutators,
+			func(ctx context.Context, kust *types.Kustomization, ads *Ads) error {
+				patch, err := ResourcesPatch(kind, name, container, res)
+				if err != nil {
+					return err
+				}
+				kust.Patches = append(kust.Patches, patch)
+				return nil
+			},
+		)
+	}
+}
+
+// ResourcesPatch returns a strategic merge patch setting the resource requests and limits of a
+// container in the pod template of the object of the provided kind and name.
+func ResourcesPatch(
+	kind, name, container string, res corev1.ResourceRequirements,
+) (types.Patch, error) {
+	content := map[string]interface{}{
+		"kind": kind,
+		"metadata": map[string]interface{}{
+			"name": name,
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name":      container,
+							"resources": res,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	dt, err := yaml.Marshal(content)
+	if err != nil {
+		return types.Patch{}, fmt.Errorf("error marshaling resources patch: %w", err)
+	}
+
+	return types.Patch{
+		Patch: string(dt),
+		Target: &types.Selector{
+			ResId: resid.ResId{
+				Gvk:  resid.Gvk{Kind: kind},
+				Name: name,
+			},
+		},
+	}, nil
+}
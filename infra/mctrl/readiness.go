@@ -0,0 +1,404 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ObjectStatus holds the readiness of a single object.
+type ObjectStatus struct {
+	Ref     ObjectRef
+	Ready   bool
+	Message string
+}
+
+// ReadyCondition is the type of the condition KustCtrl.Status uses to report the aggregated
+// readiness of all applied objects.
+const ReadyCondition = "Ready"
+
+// readinessRule evaluates the readiness of an object of a given kind. Returns whether the object
+// is ready and a message describing why it is not.
+type readinessRule func(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error)
+
+// readinessRules maps group kinds (<group>/<kind>) into their readiness rule. Kinds not present
+// here are evaluated by genericReady.
+var readinessRules = map[string]readinessRule{
+	"apps/Deployment":        deploymentReady,
+	"apps/StatefulSet":       statefulSetReady,
+	"apps/DaemonSet":         daemonSetReady,
+	"apps/ReplicaSet":        replicaSetReady,
+	"batch/Job":              jobReady,
+	"/PersistentVolumeClaim": pvcReady,
+	"/Pod":                   podReady,
+	"/Service":               serviceReady,
+}
+
+// Status returns the aggregated readiness of all objects applied by this controller. Each object
+// is evaluated according to its kind, e.g. deployments must be rolled out, persistent volume
+// claims must be bound and jobs must be complete. Kinds without a specific rule are considered
+// ready once they exist and, if they report them, their observed generation is current and their
+// Ready condition is not false. In a new process the list of applied objects is read from the
+// inventory (see Recover). Objects are read through Reader, i.e. from the cache when watching.
+func (k *KustCtrl) Status(ctx context.Context) (*Status, error) {
+	if k.Overlay() == NotAppliedOverlay {
+		return nil, fmt.Errorf("no overlay applied to the controller")
+	}
+
+	status := &Status{Ready: true}
+	var notready []string
+	for _, ref := range k.appliedRefs() {
+		ostatus, err := k.objectStatus(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		status.Objects = append(status.Objects, ostatus)
+		if ostatus.Ready {
+			continue
+		}
+
+		status.Ready = false
+		notready = append(notready, fmt.Sprintf("%s: %s", ref, ostatus.Message))
+	}
+
+	cond := metav1.Condition{
+		Type:               ReadyCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             "ObjectsReady",
+		Message:            fmt.Sprintf("%d objects ready", len(status.Objects)),
+		LastTransitionTime: metav1.Now(),
+	}
+	if !status.Ready {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = "ObjectsNotReady"
+		cond.Message = strings.Join(notready, "; ")
+	}
+
+	status.Message = cond.Message
+	status.Conditions = []metav1.Condition{cond}
+	k.reportStatus(ctx, status)
+	return status, nil
+}
+
+// objectStatus reads the referred object and evaluates its readiness.
+func (k *KustCtrl) objectStatus(ctx context.Context, ref ObjectRef) (ObjectStatus, error) {
+	ostatus := ObjectStatus{Ref: ref}
+
+	obj, err := k.live(ctx, k.Reader(), ref)
+	if err != nil {
+		return ostatus, err
+	} else if obj == nil {
+		ostatus.Message = "not found"
+		return ostatus, nil
+	}
+
+	gk := obj.GroupVersionKind().GroupKind()
+	rule, ok := readinessRules[fmt.Sprintf("%s/%s", gk.Group, gk.Kind)]
+	if !ok {
+		rule = genericReady
+	}
+
+	if ostatus.Ready, ostatus.Message, err = rule(ctx, k.Reader(), obj); err != nil {
+		return ostatus, fmt.Errorf("error evaluating %s readiness: %w", ref, err)
+	}
+	if ostatus.Ready && ostatus.Message == "" {
+		ostatus.Message = "ready"
+	}
+	return ostatus, nil
+}
+
+// nestedInt returns the int64 at the provided path, zero if it does not exist.
+func nestedInt(obj *unstructured.Unstructured, fields ...string) int64 {
+	val, _, _ := unstructured.NestedInt64(obj.Object, fields...)
+	return val
+}
+
+// nestedString returns the string at the provided path, empty if it does not exist.
+func nestedString(obj *unstructured.Unstructured, fields ...string) string {
+	val, _, _ := unstructured.NestedString(obj.Object, fields...)
+	return val
+}
+
+// replicas returns the requested number of replicas, defaults to one if not set.
+func replicas(obj *unstructured.Unstructured) int64 {
+	val, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		return 1
+	}
+	return val
+}
+
+// condition returns the status and message of the condition of the provided type. Returns
+// empty strings if the object does not report such condition.
+func condition(obj *unstructured.Unstructured, ctype string) (string, string) {
+	conds, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, raw := range conds {
+		cond, ok := raw.(map[string]interface{})
+		if !ok || cond["type"] != ctype {
+			continue
+		}
+		status, _ := cond["status"].(string)
+		message, _ := cond["message"].(string)
+		return status, message
+	}
+	return "", ""
+}
+
+// generationCurrent returns true if the object status reflects its latest spec.
+func generationCurrent(obj *unstructured.Unstructured) bool {
+	observed, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	return !found || observed >= obj.GetGeneration()
+}
+
+// genericReady considers an object ready if its observed generation, when reported, is current
+// and its Ready condition, when reported, is not false.
+func genericReady(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error) {
+	if !generationCurrent(obj) {
+		return false, "latest generation not yet observed", nil
+	}
+	if status, msg := condition(obj, "Ready"); status == string(metav1.ConditionFalse) {
+		return false, fmt.Sprintf("not ready: %s", msg), nil
+	}
+	return true, "", nil
+}
+
+// deploymentReady considers a deployment ready once all requested replicas have been updated
+// and are available. A deployment scaled down to zero is ready only when no pods matching its
+// selector remain, this includes terminating pods.
+func deploymentReady(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error) {
+	if !generationCurrent(obj) {
+		return false, "latest generation not yet observed", nil
+	}
+
+	want := replicas(obj)
+	if want == 0 {
+		return noPodsLeft(ctx, cli, obj)
+	}
+
+	updated := nestedInt(obj, "status", "updatedReplicas")
+	available := nestedInt(obj, "status", "availableReplicas")
+	total := nestedInt(obj, "status", "replicas")
+	if updated != want || available != want || total != want {
+		return false, fmt.Sprintf(
+			"%d/%d replicas updated, %d/%d available", updated, want, available, want,
+		), nil
+	}
+	return true, "", nil
+}
+
+// noPodsLeft returns true if there are no pods matching the workload selector.
+func noPodsLeft(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error) {
+	labels, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector", "matchLabels")
+	if len(labels) == 0 {
+		return true, "", nil
+	}
+
+	var pods corev1.PodList
+	if err := cli.List(
+		ctx, &pods, client.InNamespace(obj.GetNamespace()), client.MatchingLabels(labels),
+	); err != nil {
+		return false, "", fmt.Errorf("error listing pods: %w", err)
+	}
+
+	if len(pods.Items) > 0 {
+		return false, fmt.Sprintf("%d pods still present", len(pods.Items)), nil
+	}
+	return true, "", nil
+}
+
+// statefulSetReady considers a stateful set ready once all replicas are ready and running the
+// latest revision.
+func statefulSetReady(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error) {
+	if !generationCurrent(obj) {
+		return false, "latest generation not yet observed", nil
+	}
+
+	want := replicas(obj)
+	if want == 0 {
+		return noPodsLeft(ctx, cli, obj)
+	}
+
+	ready := nestedInt(obj, "status", "readyReplicas")
+	updated := nestedInt(obj, "status", "updatedReplicas")
+	if ready != want || updated != want {
+		return false, fmt.Sprintf(
+			"%d/%d replicas ready, %d/%d updated", ready, want, updated, want,
+		), nil
+	}
+
+	current := nestedString(obj, "status", "currentRevision")
+	update := nestedString(obj, "status", "updateRevision")
+	if current != update {
+		return false, "rollout in progress", nil
+	}
+	return true, "", nil
+}
+
+// daemonSetReady considers a daemon set ready once it is available and updated on all nodes.
+func daemonSetReady(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error) {
+	if !generationCurrent(obj) {
+		return false, "latest generation not yet observed", nil
+	}
+
+	want := nestedInt(obj, "status", "desiredNumberScheduled")
+	available := nestedInt(obj, "status", "numberAvailable")
+	updated := nestedInt(obj, "status", "updatedNumberScheduled")
+	if available != want || updated != want {
+		return false, fmt.Sprintf(
+			"%d/%d pods available, %d/%d updated", available, want, updated, want,
+		), nil
+	}
+	return true, "", nil
+}
+
+// replicaSetReady considers a replica set ready once all requested replicas are available.
+func replicaSetReady(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error) {
+	if !generationCurrent(obj) {
+		return false, "latest generation not yet observed", nil
+	}
+
+	want := replicas(obj)
+	available := nestedInt(obj, "status", "availableReplicas")
+	if available != want {
+		return false, fmt.Sprintf("%d/%d replicas available", available, want), nil
+	}
+	return true, "", nil
+}
+
+// jobReady considers a job ready once it is complete.
+func jobReady(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error) {
+	if status, msg := condition(obj, "Failed"); status == string(metav1.ConditionTrue) {
+		return false, fmt.Sprintf("job failed: %s", msg), nil
+	}
+	if status, _ := condition(obj, "Complete"); status != string(metav1.ConditionTrue) {
+		return false, "job not complete", nil
+	}
+	return true, "", nil
+}
+
+// SelectedNodeAnnotation is the annotation the scheduler sets on claims whose storage class binds
+// on WaitForFirstConsumer, it holds the node the first consumer has been scheduled to.
+// DefaultClassAnnotation flags the storage class used by claims that do not set one.
+const (
+	SelectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+	DefaultClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+)
+
+// pvcReady considers a persistent volume claim ready once it is bound. Claims whose storage class
+// binds on WaitForFirstConsumer are ready while pending if no consumer has been scheduled yet (no
+// SelectedNodeAnnotation) as they are only bound once a pod uses them, e.g. a claim used only by
+// a cron job stays pending until the first run.
+func pvcReady(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error) {
+	phase := nestedString(obj, "status", "phase")
+	if phase == string(corev1.ClaimBound) {
+		return true, "", nil
+	}
+
+	// claims not yet defaulted by the API server have no phase, they are pending.
+	_, selected := obj.GetAnnotations()[SelectedNodeAnnotation]
+	pending := phase == "" || phase == string(corev1.ClaimPending)
+	if pending && !selected {
+		var class *string
+		if name, found, _ := unstructured.NestedString(
+			obj.Object, "spec", "storageClassName",
+		); found {
+			class = &name
+		}
+
+		mode, err := ClaimBindingMode(ctx, cli, class)
+		if err != nil {
+			return false, "", err
+		}
+		if mode == storagev1.VolumeBindingWaitForFirstConsumer {
+			return true, "claim waiting for first consumer", nil
+		}
+	}
+	return false, fmt.Sprintf("claim not bound (%s)", phase), nil
+}
+
+// ClaimBindingMode returns the binding mode of the storage class called 'class'. If 'class' is
+// nil the default storage class, flagged through DefaultClassAnnotation, is used instead. Returns
+// VolumeBindingImmediate if the storage class does not exist.
+func ClaimBindingMode(
+	ctx context.Context, cli client.Reader, class *string,
+) (storagev1.VolumeBindingMode, error) {
+	var classes storagev1.StorageClassList
+	if err := cli.List(ctx, &classes); err != nil {
+		return "", fmt.Errorf("error listing storage classes: %w", err)
+	}
+
+	for _, sc := range classes.Items {
+		if class != nil {
+			if sc.Name != *class {
+				continue
+			}
+		} else if sc.Annotations[DefaultClassAnnotation] != "true" {
+			continue
+		}
+
+		if sc.VolumeBindingMode == nil {
+			return storagev1.VolumeBindingImmediate, nil
+		}
+		return *sc.VolumeBindingMode, nil
+	}
+	return storagev1.VolumeBindingImmediate, nil
+}
+
+// podReady considers a pod ready if it has succeeded or if it is running and ready.
+func podReady(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error) {
+	phase := nestedString(obj, "status", "phase")
+	switch phase {
+	case string(corev1.PodSucceeded):
+		return true, "", nil
+	case string(corev1.PodRunning):
+		if status, _ := condition(obj, "Ready"); status == string(metav1.ConditionTrue) {
+			return true, "", nil
+		}
+		return false, "pod not ready", nil
+	default:
+		return false, fmt.Sprintf("pod %s", strings.ToLower(phase)), nil
+	}
+}
+
+// serviceReady considers a service ready once it exists, services of type LoadBalancer are
+// ready only after an ingress has been assigned.
+func serviceReady(
+	ctx context.Context, cli client.Reader, obj *unstructured.Unstructured,
+) (bool, string, error) {
+	if nestedString(obj, "spec", "type") != string(corev1.ServiceTypeLoadBalancer) {
+		return true, "", nil
+	}
+
+	ingress, _, _ := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+	if len(ingress) == 0 {
+		return false, "load balancer ingress not yet assigned", nil
+	}
+	return true, "", nil
+}
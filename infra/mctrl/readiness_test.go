@@ -0,0 +1,308 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ricardomaraschini/freighter/infra/resource"
+)
+
+// evaluate parses the manifest and evaluates its readiness through the rule registered for its
+// kind, genericReady is used for kinds without a rule.
+func evaluate(t *testing.T, cli client.Reader, manifest string) (bool, string) {
+	t.Helper()
+
+	dt, err := yaml.YAMLToJSON([]byte(manifest))
+	if err != nil {
+		t.Fatalf("error converting manifest: %s", err)
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(dt); err != nil {
+		t.Fatalf("error parsing manifest: %s", err)
+	}
+
+	gk := obj.GroupVersionKind().GroupKind()
+	rule, ok := readinessRules[fmt.Sprintf("%s/%s", gk.Group, gk.Kind)]
+	if !ok {
+		rule = genericReady
+	}
+
+	ready, msg, err := rule(context.Background(), cli, obj)
+	if err != nil {
+		t.Fatalf("error evaluating readiness: %s", err)
+	}
+	return ready, msg
+}
+
+func TestReadinessRules(t *testing.T) {
+	wffc := storagev1.VolumeBindingWaitForFirstConsumer
+	cli := fake.NewClientBuilder().WithScheme(resource.Scheme).WithObjects(
+		&storagev1.StorageClass{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "local",
+				Annotations: map[string]string{DefaultClassAnnotation: "true"},
+			},
+			VolumeBindingMode: &wffc,
+		},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "immediate"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "test",
+				Name:      "leftover",
+				Labels:    map[string]string{"app": "leftover"},
+			},
+		},
+	).Build()
+
+	for _, tt := range []struct {
+		name     string
+		manifest string
+		ready    bool
+		message  string
+	}{
+		{
+			name: "deployment generation not observed",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: app, generation: 2}
+spec: {replicas: 1}
+status: {observedGeneration: 1, replicas: 1, updatedReplicas: 1, availableReplicas: 1}`,
+			message: "latest generation not yet observed",
+		},
+		{
+			name: "deployment rolling out",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: app}
+spec: {replicas: 2}
+status: {replicas: 3, updatedReplicas: 2, availableReplicas: 1}`,
+			message: "2/2 replicas updated, 1/2 available",
+		},
+		{
+			name: "deployment rolled out",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: app}
+status: {replicas: 1, updatedReplicas: 1, availableReplicas: 1}`,
+			ready: true,
+		},
+		{
+			name: "deployment scaled down with pods left",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: app, namespace: test}
+spec: {replicas: 0, selector: {matchLabels: {app: leftover}}}`,
+			message: "1 pods still present",
+		},
+		{
+			name: "deployment scaled down",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: app, namespace: test}
+spec: {replicas: 0, selector: {matchLabels: {app: gone}}}`,
+			ready: true,
+		},
+		{
+			name: "stateful set revision mismatch",
+			manifest: `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: db}
+spec: {replicas: 1}
+status: {readyReplicas: 1, updatedReplicas: 1, currentRevision: a, updateRevision: b}`,
+			message: "rollout in progress",
+		},
+		{
+			name: "stateful set ready",
+			manifest: `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: db}
+spec: {replicas: 1}
+status: {readyReplicas: 1, updatedReplicas: 1, currentRevision: a, updateRevision: a}`,
+			ready: true,
+		},
+		{
+			name: "daemon set partially available",
+			manifest: `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata: {name: agent}
+status: {desiredNumberScheduled: 3, numberAvailable: 2, updatedNumberScheduled: 3}`,
+			message: "2/3 pods available, 3/3 updated",
+		},
+		{
+			name: "replica set available",
+			manifest: `
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata: {name: app}
+spec: {replicas: 2}
+status: {availableReplicas: 2}`,
+			ready: true,
+		},
+		{
+			name: "job failed",
+			manifest: `
+apiVersion: batch/v1
+kind: Job
+metadata: {name: migrate}
+status: {conditions: [{type: Failed, status: "True", message: backoff limit}]}`,
+			message: "job failed: backoff limit",
+		},
+		{
+			name: "job running",
+			manifest: `
+apiVersion: batch/v1
+kind: Job
+metadata: {name: migrate}`,
+			message: "job not complete",
+		},
+		{
+			name: "job complete",
+			manifest: `
+apiVersion: batch/v1
+kind: Job
+metadata: {name: migrate}
+status: {conditions: [{type: Complete, status: "True"}]}`,
+			ready: true,
+		},
+		{
+			name: "claim bound",
+			manifest: `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata: {name: data}
+status: {phase: Bound}`,
+			ready: true,
+		},
+		{
+			name: "claim waiting for first consumer",
+			manifest: `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata: {name: data}
+status: {phase: Pending}`,
+			ready:   true,
+			message: "claim waiting for first consumer",
+		},
+		{
+			name: "claim consumer scheduled",
+			manifest: `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  annotations: {volume.kubernetes.io/selected-node: node}
+status: {phase: Pending}`,
+			message: "claim not bound (Pending)",
+		},
+		{
+			name: "claim with immediate binding",
+			manifest: `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata: {name: data}
+spec: {storageClassName: immediate}
+status: {phase: Pending}`,
+			message: "claim not bound (Pending)",
+		},
+		{
+			name: "pod succeeded",
+			manifest: `
+apiVersion: v1
+kind: Pod
+metadata: {name: task}
+status: {phase: Succeeded}`,
+			ready: true,
+		},
+		{
+			name: "pod running not ready",
+			manifest: `
+apiVersion: v1
+kind: Pod
+metadata: {name: app}
+status: {phase: Running, conditions: [{type: Ready, status: "False"}]}`,
+			message: "pod not ready",
+		},
+		{
+			name: "pod pending",
+			manifest: `
+apiVersion: v1
+kind: Pod
+metadata: {name: app}
+status: {phase: Pending}`,
+			message: "pod pending",
+		},
+		{
+			name: "cluster ip service",
+			manifest: `
+apiVersion: v1
+kind: Service
+metadata: {name: app}`,
+			ready: true,
+		},
+		{
+			name: "load balancer without ingress",
+			manifest: `
+apiVersion: v1
+kind: Service
+metadata: {name: app}
+spec: {type: LoadBalancer}`,
+			message: "load balancer ingress not yet assigned",
+		},
+		{
+			name: "load balancer with ingress",
+			manifest: `
+apiVersion: v1
+kind: Service
+metadata: {name: app}
+spec: {type: LoadBalancer}
+status: {loadBalancer: {ingress: [{ip: 10.0.0.1}]}}`,
+			ready: true,
+		},
+		{
+			name: "generic kind without status",
+			manifest: `
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: config}`,
+			ready: true,
+		},
+		{
+			name: "generic kind not ready",
+			manifest: `
+apiVersion: example.com/v1
+kind: Database
+metadata: {name: db}
+status: {conditions: [{type: Ready, status: "False", message: provisioning}]}`,
+			message: "not ready: provisioning",
+		},
+	} {
+		t.Run(strings.ReplaceAll(tt.name, " ", "-"), func(t *testing.T) {
+			ready, msg := evaluate(t, cli, tt.manifest)
+			if ready != tt.ready || msg != tt.message {
+				t.Fatalf(
+					"got ready=%t message=%q, expected ready=%t message=%q",
+					ready, msg, tt.ready, tt.message,
+				)
+			}
+		})
+	}
+}
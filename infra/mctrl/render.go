@@ -0,0 +1,179 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// renderOnlyKey is the context key used to flag render only calls.
+type renderOnlyKey struct{}
+
+// RenderOnly returns a copy of the context flagged as render only. KMutators and OMutators can
+// inspect the flag through IsRenderOnly and must not write anything to the cluster when it is
+// set.
+func RenderOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, renderOnlyKey{}, true)
+}
+
+// IsRenderOnly returns true if the context has been flagged as render only.
+func IsRenderOnly(ctx context.Context) bool {
+	ro, _ := ctx.Value(renderOnlyKey{}).(bool)
+	return ro
+}
+
+// Renderer is implemented by MicroControllers capable of rendering an overlay without writing to
+// the cluster. Render returns the objects the MicroController would apply while RenderAds returns
+// the Ads it would advertise once moved to the overlay. Values not known in advance (e.g. not
+// yet generated passwords) may be replaced by placeholders.
+type Renderer interface {
+	Render(ctx context.Context, overlay string, ads *Ads) ([]client.Object, error)
+	RenderAds(ctx context.Context, overlay string) (*Ads, error)
+}
+
+// OverlayLister is implemented by MicroControllers capable of listing the overlays they provide.
+type OverlayLister interface {
+	Overlays() ([]string, error)
+}
+
+// Overlays returns the sorted list of overlays provided by the controller, i.e. the directories
+// holding a kustomization.yaml under the kustomize directory. BaseOverlay is included as well as
+// overlays added through layers.
+func (k *KustCtrl) Overlays() ([]string, error) {
+	virtfs, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := virtfs.ReadDir("kustomize")
+	if err != nil {
+		return nil, fmt.Errorf("error reading overlays: %w", err)
+	}
+
+	var overlays []string
+	for _, entry := range entries {
+		if virtfs.Exists(path.Join("kustomize", entry, "kustomization.yaml")) {
+			overlays = append(overlays, entry)
+		}
+	}
+	sort.Strings(overlays)
+	return overlays, nil
+}
+
+// Render returns the objects that would be created if the provided overlay was applied with the
+// provided Ads. The objects go through the same KMutators and OMutators as they would during an
+// Apply call but nothing is written to the cluster, the context passed down to the mutators is
+// flagged as render only (see IsRenderOnly).
+func (k *KustCtrl) Render(ctx context.Context, overlay string, ads *Ads) ([]client.Object, error) {
+	return k.render(RenderOnly(ctx), overlay, ads)
+}
+
+// render parses the kustomize files and feeds all registered OMutators with the parsed objects.
+// Objects are labeled with the InstanceLabel after going through the OMutators. Render duration
+// and failures are recorded in the render metrics.
+func (k *KustCtrl) render(
+	ctx context.Context, overlay string, ads *Ads,
+) (objs []client.Object, err error) {
+	defer func(start time.Time) {
+		observeDuration(renderDuration, renderErrors, start, err, k.namespace, k.name, overlay)
+	}(time.Now())
+
+	objs, err = k.parse(ctx, overlay, ads)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing kustomize files: %w", err)
+	}
+
+	logger := k.Logger(ctx)
+	for _, obj := range objs {
+		values := refValues(RefFor(obj))
+		for i, mut := range k.OMutators {
+			logger.V(2).Info("running object mutator", append(values, LogKeyMutator, i)...)
+			if err := mut(ctx, obj); err != nil {
+				return nil, fmt.Errorf("error mutating object: %w", err)
+			}
+		}
+		if err := k.setInstanceLabel(obj); err != nil {
+			return nil, fmt.Errorf("error labeling object: %w", err)
+		}
+	}
+	return objs, nil
+}
+
+// Render returns the objects all registered MicroControllers would create when moved to the
+// provided overlay. All MicroControllers must implement the Renderer interface. Ads are passed
+// from one MicroController to the next as they would during Apply, for teardown overlays Ads are
+// rendered as if the providers were still at BaseOverlay as consumers are torn down first.
+func (s *Stack) Render(ctx context.Context, overlay string) ([]client.Object, error) {
+	return s.RenderSelected(ctx, overlay, nil)
+}
+
+// RenderSelected works as Render but returns only the objects of the MicroControllers whose
+// names are present in 'names'. The Ads of all MicroControllers are still rendered so they flow
+// as they would during Apply. An empty 'names' selects all MicroControllers.
+func (s *Stack) RenderSelected(
+	ctx context.Context, overlay string, names []string,
+) ([]client.Object, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	var objs []client.Object
+	err := s.walkRender(
+		ctx, overlay, func(m *member, rnd Renderer, ads *Ads) error {
+			if len(selected) > 0 && !selected[m.name] {
+				return nil
+			}
+
+			mobjs, err := rnd.Render(ctx, overlay, ads)
+			if err != nil {
+				return fmt.Errorf("error rendering %q: %w", m.name, err)
+			}
+			objs = append(objs, mobjs...)
+			return nil
+		},
+	)
+	return objs, err
+}
+
+// walkRender calls 'fn' for each member in dependency order. Each call receives the Ads rendered
+// by the members visited so far. All members must implement the Renderer interface.
+func (s *Stack) walkRender(
+	ctx context.Context, overlay string, fn func(*member, Renderer, *Ads) error,
+) error {
+	members, err := s.sorted()
+	if err != nil {
+		return fmt.Errorf("invalid stack: %w", err)
+	}
+
+	adsOverlay := overlay
+	if s.teardowns[overlay] {
+		adsOverlay = BaseOverlay
+	}
+
+	ads := NewAds()
+	for _, m := range members {
+		rnd, ok := m.mctrl.(Renderer)
+		if !ok {
+			return fmt.Errorf("%q does not support rendering", m.name)
+		}
+
+		if err := fn(m, rnd, ads); err != nil {
+			return err
+		}
+
+		mads, err := rnd.RenderAds(ctx, adsOverlay)
+		if err != nil {
+			return fmt.Errorf("error rendering %q ads: %w", m.name, err)
+		}
+
+		if err := ads.Merge(m.name, mads); err != nil {
+			return err
+		}
+	}
+	return nil
+}
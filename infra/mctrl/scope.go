@@ -0,0 +1,76 @@
+package mctrl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scope identifies a provider instance within Ads. Different instances of the same component
+// (e.g. two postgres databases) advertise the same indexes, to avoid collisions all indexes are
+// advertised under the scope of the instance in the format <prefix>/<component>/<name>. Prefix
+// is usually the name prefix used by the controller instance.
+type Scope struct {
+	Prefix    string
+	Component string
+}
+
+// Key returns the scoped index for 'name'.
+func (s Scope) Key(name string) string {
+	return fmt.Sprintf("%s/%s/%s", s.Prefix, s.Component, name)
+}
+
+// Keys returns the scoped indexes for all provided names.
+func (s Scope) Keys(names ...string) []string {
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, s.Key(name))
+	}
+	return keys
+}
+
+// String returns the scope in the <prefix>/<component> format.
+func (s Scope) String() string {
+	return fmt.Sprintf("%s/%s", s.Prefix, s.Component)
+}
+
+// ParseKey splits a scoped index into its scope and name. Returns an error if the index is not
+// in the <prefix>/<component>/<name> format.
+func ParseKey(idx string) (Scope, string, error) {
+	parts := strings.SplitN(idx, "/", 3)
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return Scope{}, "", fmt.Errorf("%q is not a scoped index", idx)
+	}
+	return Scope{Prefix: parts[0], Component: parts[1]}, parts[2], nil
+}
+
+// In returns a new Ads holding only the indexes advertised under the provided scope, indexes in
+// the returned Ads are stripped from the scope. This allows consumers to bind to a specific
+// provider instance, e.g. ads.In(Scope{"clair", "postgres"}).Get("dbhost"). Providers and
+// sensitivity flags are kept.
+func (a *Ads) In(scope Scope) *Ads {
+	out := NewAds()
+	prefix := scope.Key("")
+	for idx, in := range a.entries() {
+		if !strings.HasPrefix(idx, prefix) {
+			continue
+		}
+		out.dict[strings.TrimPrefix(idx, prefix)] = in
+	}
+	return out
+}
+
+// Instances returns the scopes of all instances of the provided component that advertised at
+// least one index.
+func (a *Ads) Instances(component string) []Scope {
+	seen := map[Scope]bool{}
+	var scopes []Scope
+	for _, idx := range a.Keys() {
+		scope, _, err := ParseKey(idx)
+		if err != nil || scope.Component != component || seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
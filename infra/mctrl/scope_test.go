@@ -0,0 +1,55 @@
+package mctrl_test
+
+import (
+	"testing"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+func TestParseKey(t *testing.T) {
+	for _, tt := range []struct {
+		idx   string
+		scope mctrl.Scope
+		name  string
+		err   bool
+	}{
+		{
+			idx:   "clair/postgres/dbhost",
+			scope: mctrl.Scope{Prefix: "clair", Component: "postgres"},
+			name:  "dbhost",
+		},
+		{
+			idx:   "/redis/address",
+			scope: mctrl.Scope{Component: "redis"},
+			name:  "address",
+		},
+		{
+			idx:   "quay/postgres/nested/name",
+			scope: mctrl.Scope{Prefix: "quay", Component: "postgres"},
+			name:  "nested/name",
+		},
+		{idx: "dbhost", err: true},
+		{idx: "postgres/dbhost", err: true},
+		{idx: "clair//dbhost", err: true},
+		{idx: "clair/postgres/", err: true},
+	} {
+		t.Run(tt.idx, func(t *testing.T) {
+			scope, name, err := mctrl.ParseKey(tt.idx)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, parsed as %s and %q", scope, name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if scope != tt.scope || name != tt.name {
+				t.Fatalf("parsed as %s and %q, expected %s and %q", scope, name, tt.scope, tt.name)
+			}
+			if key := scope.Key(name); key != tt.idx {
+				t.Fatalf("scope key %q differs from %q", key, tt.idx)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package mctrl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultFieldManager is the field manager used by KustCtrl when applying objects through server
+// side apply, see WithFieldManager.
+const DefaultFieldManager = "freighter"
+
+// LegacyFieldManager is the field manager used by freighter before the field manager became
+// configurable. Fields of objects applied by older versions are owned by it. To migrate these
+// objects, conflicts involving only LegacyFieldManager are not reported: the apply is retried
+// forcing ownership, moving the conflicting fields to the configured manager. This happens
+// regardless of WithForceOwnership and WithConflictResolver. Fields that never conflict stay
+// co-owned by LegacyFieldManager, no manual step is needed.
+const LegacyFieldManager = "undefined"
+
+// WithFieldManager sets the field manager used when applying objects. Different controllers (or
+// different processes) can use different managers to keep track of who owns each field.
+func WithFieldManager(manager string) KustOption {
+	return func(k *KustCtrl) {
+		k.fowner = manager
+	}
+}
+
+// WithForceOwnership makes the controller take ownership of conflicting fields when applying
+// objects. By default conflicts are not forced and a ConflictError is returned instead.
+func WithForceOwnership() KustOption {
+	return func(k *KustCtrl) {
+		k.force = true
+	}
+}
+
+// WithConflictResolver registers a function called every time applying an object results in a
+// conflict. If the function returns true the apply is retried taking ownership of the conflicting
+// fields, otherwise the ConflictError is returned. Unused when WithForceOwnership is set.
+func WithConflictResolver(fn func(context.Context, *ConflictError) bool) KustOption {
+	return func(k *KustCtrl) {
+		k.resolver = fn
+	}
+}
+
+// FieldConflict describes a single field managed by a different field manager.
+type FieldConflict struct {
+	Manager string
+	Field   string
+	Message string
+}
+
+// ConflictError is returned when applying an object fails because one or more of its fields are
+// managed by a different field manager (e.g. someone edited the object through kubectl).
+type ConflictError struct {
+	Ref       ObjectRef
+	Conflicts []FieldConflict
+	Err       error
+}
+
+// Error returns a description of the conflict listing the conflicting fields and managers.
+func (c *ConflictError) Error() string {
+	var fields []string
+	for _, conflict := range c.Conflicts {
+		fields = append(fields, fmt.Sprintf("%s (%s)", conflict.Field, conflict.Manager))
+	}
+	return fmt.Sprintf("conflict applying %s: %s", c.Ref, strings.Join(fields, ", "))
+}
+
+// Unwrap returns the error returned by the API server.
+func (c *ConflictError) Unwrap() error {
+	return c.Err
+}
+
+// onlyWith returns true if all conflicting fields are managed by the provided manager.
+func (c *ConflictError) onlyWith(manager string) bool {
+	managers := c.Managers()
+	return len(managers) == 1 && managers[0] == manager
+}
+
+// Managers returns the distinct field managers involved in the conflict.
+func (c *ConflictError) Managers() []string {
+	seen := map[string]bool{}
+	var managers []string
+	for _, conflict := range c.Conflicts {
+		if seen[conflict.Manager] {
+			continue
+		}
+		seen[conflict.Manager] = true
+		managers = append(managers, conflict.Manager)
+	}
+	return managers
+}
+
+// managerRE extracts the manager from a conflict cause message. Messages are in the format
+// 'conflict with "<manager>" using <api version>: <field>', the api version part is optional.
+var managerRE = regexp.MustCompile(`^conflict with "([^"]*)"`)
+
+// conflictError converts a server side apply conflict into a ConflictError. Returns nil if the
+// provided error is not a conflict.
+func conflictError(ref ObjectRef, err error) *ConflictError {
+	var status apierrors.APIStatus
+	if !apierrors.IsConflict(err) || !errors.As(err, &status) {
+		return nil
+	}
+
+	cerr := &ConflictError{Ref: ref, Err: err}
+	details := status.Status().Details
+	if details == nil {
+		return cerr
+	}
+
+	for _, cause := range details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+
+		conflict := FieldConflict{
+			Field:   cause.Field,
+			Message: cause.Message,
+		}
+		if match := managerRE.FindStringSubmatch(cause.Message); match != nil {
+			conflict.Manager = match[1]
+		}
+		cerr.Conflicts = append(cerr.Conflicts, conflict)
+	}
+	return cerr
+}
+
+// patch applies the object through server side apply using the configured field manager. Fields
+// are forced according to the configured policy, conflicts are returned as ConflictError. Fields
+// owned by LegacyFieldManager are always taken over. Conflicts found on dry runs are neither
+// counted nor handed to the resolver as nothing is going to be written.
+func (k *KustCtrl) patch(ctx context.Context, obj client.Object, opts ...client.PatchOption) error {
+	popts := append([]client.PatchOption{client.FieldOwner(k.fowner)}, opts...)
+	if k.force {
+		popts = append(popts, client.ForceOwnership)
+	}
+
+	k.Logger(ctx).V(1).Info(
+		"patching object", append(refValues(RefFor(obj)), LogKeyFieldManager, k.fowner)...,
+	)
+
+	err := k.cli.Patch(ctx, obj, client.Apply, popts...)
+	cerr := conflictError(RefFor(obj), err)
+	if cerr == nil {
+		return err
+	}
+
+	applied := &client.PatchOptions{}
+	applied.ApplyOptions(popts)
+	dryRun := len(applied.DryRun) > 0
+	if !dryRun {
+		k.countConflicts(cerr)
+	}
+
+	if k.fowner != LegacyFieldManager && cerr.onlyWith(LegacyFieldManager) {
+		k.Logger(ctx).Info(
+			"taking over fields from legacy field manager",
+			append(refValues(cerr.Ref), LogKeyFieldManager, k.fowner)...,
+		)
+		return k.cli.Patch(ctx, obj, client.Apply, append(popts, client.ForceOwnership)...)
+	}
+
+	if dryRun || k.force || k.resolver == nil || !k.resolver(ctx, cerr) {
+		return cerr
+	}
+	return k.cli.Patch(ctx, obj, client.Apply, append(popts, client.ForceOwnership)...)
+}
@@ -0,0 +1,242 @@
+package mctrl_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+	"github.com/ricardomaraschini/freighter/infra/resource"
+)
+
+// conflictClient refuses, with a server side apply conflict, all patches against the object
+// called 'name' unless ownership is forced. Conflicting fields are managed by 'managers', there
+// is no conflict if empty. The options of all patches against the object are recorded.
+type conflictClient struct {
+	client.Client
+	name     string
+	managers []string
+	patches  []*client.PatchOptions
+}
+
+// Patch returns a conflict for the object called 'name' if ownership is not forced.
+func (c *conflictClient) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	if obj.GetName() != c.name {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	popts := &client.PatchOptions{}
+	popts.ApplyOptions(opts)
+	c.patches = append(c.patches, popts)
+	if len(c.managers) == 0 || (popts.Force != nil && *popts.Force) {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	var causes []metav1.StatusCause
+	for _, manager := range c.managers {
+		causes = append(
+			causes,
+			metav1.StatusCause{
+				Type:    metav1.CauseTypeFieldManagerConflict,
+				Message: fmt.Sprintf("conflict with %q using v1: .data.key", manager),
+				Field:   ".data.key",
+			},
+		)
+	}
+	return apierrors.NewApplyConflict(causes, "apply failed with conflicts")
+}
+
+// forced returns, for every recorded patch, whether ownership was forced.
+func (c *conflictClient) forced() []bool {
+	var out []bool
+	for _, popts := range c.patches {
+		out = append(out, popts.Force != nil && *popts.Force)
+	}
+	return out
+}
+
+func TestConflict(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		managers []string
+		opts     []mctrl.KustOption
+		conflict bool
+		forced   []bool
+	}{
+		{
+			name:     "reported",
+			managers: []string{"kubectl"},
+			conflict: true,
+			forced:   []bool{false},
+		},
+		{
+			name:     "forced",
+			managers: []string{"kubectl"},
+			opts:     []mctrl.KustOption{mctrl.WithForceOwnership()},
+			forced:   []bool{true},
+		},
+		{
+			name:     "resolved",
+			managers: []string{"kubectl"},
+			opts: []mctrl.KustOption{
+				mctrl.WithConflictResolver(func(context.Context, *mctrl.ConflictError) bool {
+					return true
+				}),
+			},
+			forced: []bool{false, true},
+		},
+		{
+			name:     "refused",
+			managers: []string{"kubectl"},
+			opts: []mctrl.KustOption{
+				mctrl.WithConflictResolver(func(context.Context, *mctrl.ConflictError) bool {
+					return false
+				}),
+			},
+			conflict: true,
+			forced:   []bool{false},
+		},
+		{
+			name:     "legacy manager taken over",
+			managers: []string{mctrl.LegacyFieldManager},
+			forced:   []bool{false, true},
+		},
+		{
+			name:     "legacy manager among others",
+			managers: []string{mctrl.LegacyFieldManager, "kubectl"},
+			conflict: true,
+			forced:   []bool{false},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := &conflictClient{
+				Client:   mctrltest.NewClient(resource.Scheme),
+				name:     "a",
+				managers: tt.managers,
+			}
+			k := newKustCtrl(cli, tt.opts...)
+			err := k.Apply(context.Background(), mctrl.BaseOverlay, mctrl.NewAds())
+
+			var cerr *mctrl.ConflictError
+			if conflict := errors.As(err, &cerr); conflict != tt.conflict {
+				t.Fatalf("expected conflict %t, found %v", tt.conflict, err)
+			} else if !conflict && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got := cli.forced(); !reflect.DeepEqual(got, tt.forced) {
+				t.Fatalf("forced patches %v, expected %v", got, tt.forced)
+			}
+			if !tt.conflict {
+				return
+			}
+
+			if cerr.Ref != ref("a") {
+				t.Fatalf("conflict on %s, expected %s", cerr.Ref, ref("a"))
+			}
+			if managers := cerr.Managers(); !reflect.DeepEqual(managers, tt.managers) {
+				t.Fatalf("conflicting managers %v, expected %v", managers, tt.managers)
+			}
+			if field := cerr.Conflicts[0].Field; field != ".data.key" {
+				t.Fatalf("conflicting field %q", field)
+			}
+			if !apierrors.IsConflict(err) {
+				t.Fatal("conflict error does not wrap the api error")
+			}
+		})
+	}
+}
+
+func TestFieldManager(t *testing.T) {
+	cli := &conflictClient{Client: mctrltest.NewClient(resource.Scheme), name: "a"}
+
+	apply(t, newKustCtrl(cli), mctrl.BaseOverlay)
+	apply(t, newKustCtrl(cli, mctrl.WithFieldManager("operator")), mctrl.BaseOverlay)
+
+	var owners []string
+	for _, popts := range cli.patches {
+		owners = append(owners, popts.FieldManager)
+	}
+	expected := []string{mctrl.DefaultFieldManager, "operator"}
+	if !reflect.DeepEqual(owners, expected) {
+		t.Fatalf("patched with field managers %v, expected %v", owners, expected)
+	}
+}
+
+// conflicts returns how many conflicts with 'manager' were counted for the test/app controller.
+func conflicts(t *testing.T, manager string) float64 {
+	t.Helper()
+
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("error gathering metrics: %s", err)
+	}
+
+	labels := map[string]string{"namespace": "test", "component": "app", "manager": manager}
+	for _, family := range families {
+		if family.GetName() != "freighter_ssa_conflicts_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			found := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				found[label.GetName()] = label.GetValue()
+			}
+			if reflect.DeepEqual(found, labels) {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestConflictDryRun(t *testing.T) {
+	cli := &conflictClient{
+		Client:   mctrltest.NewClient(resource.Scheme),
+		name:     "a",
+		managers: []string{"dry-runner"},
+	}
+
+	var resolved bool
+	k := newKustCtrl(
+		cli,
+		mctrl.WithConflictResolver(func(context.Context, *mctrl.ConflictError) bool {
+			resolved = true
+			return true
+		}),
+	)
+
+	_, err := k.Diff(context.Background(), mctrl.BaseOverlay, mctrl.NewAds())
+	var cerr *mctrl.ConflictError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected conflict, found %v", err)
+	}
+	if resolved {
+		t.Fatal("resolver called on dry run")
+	}
+	if got := cli.forced(); !reflect.DeepEqual(got, []bool{false}) {
+		t.Fatalf("forced patches %v, expected [false]", got)
+	}
+	if count := conflicts(t, "dry-runner"); count != 0 {
+		t.Fatalf("counted %v conflicts on dry run", count)
+	}
+
+	if err := k.Apply(context.Background(), mctrl.BaseOverlay, mctrl.NewAds()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !resolved {
+		t.Fatal("resolver not called on apply")
+	}
+	if count := conflicts(t, "dry-runner"); count != 1 {
+		t.Fatalf("counted %v conflicts on apply, expected 1", count)
+	}
+}
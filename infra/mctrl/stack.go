@@ -0,0 +1,370 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Stack groups a set of MicroControllers that depend on each other through their Ads. Each
+// MicroController is registered with the list of Ads indexes it requires and the list of Ads
+// indexes it provides, the Stack then works out the order in which the MicroControllers must be
+// applied. Overlays considered teardown overlays (e.g. ScaleDownOverlay) are applied in the
+// reverse order, consumers go first and providers last.
+type Stack struct {
+	members   []*member
+	teardowns map[string]bool
+	wait      WaitOptions
+	skip      bool
+}
+
+// member is a MicroController registered in a Stack together with its dependency information.
+type member struct {
+	name     string
+	mctrl    MicroController
+	requires []string
+	provides []string
+}
+
+// NewStack returns an empty Stack. By default only the ScaleDownOverlay is considered a teardown
+// overlay, see AddTeardownOverlay.
+func NewStack() *Stack {
+	return &Stack{
+		teardowns: map[string]bool{
+			ScaleDownOverlay: true,
+		},
+	}
+}
+
+// AddTeardownOverlay marks provided overlay as a teardown overlay. Teardown overlays are applied
+// in the reverse dependency order.
+func (s *Stack) AddTeardownOverlay(overlay string) {
+	s.teardowns[overlay] = true
+}
+
+// SetWaitOptions sets the options used when waiting for each MicroController to become ready
+// after it has been applied, see WaitReady. With NoWait set Apply does not block: it stops at the
+// first MicroController not yet ready returning a NotReadyError, its consumers are left as they
+// are until a later call finds it ready.
+func (s *Stack) SetWaitOptions(opts WaitOptions) {
+	s.wait = opts
+}
+
+// SetSkipUpToDate makes Apply skip MicroControllers that are ready and up to date with the
+// overlay (see UpToDater): they are not applied again so no objects are patched and no events
+// are emitted. Changes made to their objects by someone else are then only reverted once they
+// make the MicroController not ready.
+func (s *Stack) SetSkipUpToDate(skip bool) {
+	s.skip = skip
+}
+
+// OMutable is implemented by MicroControllers accepting OMutators, e.g. the ones embedding
+// KustCtrl.
+type OMutable interface {
+	AddOMutator(fn func(context.Context, client.Object) error)
+}
+
+// AddOMutator registers an OMutator in all MicroControllers registered in the stack so far. This
+// is useful for stack wide policies, e.g. an ImageRewriter. Returns an error if a MicroController
+// does not implement OMutable.
+func (s *Stack) AddOMutator(fn func(context.Context, client.Object) error) error {
+	for _, m := range s.members {
+		mut, ok := m.mctrl.(OMutable)
+		if !ok {
+			return fmt.Errorf("%q does not accept object mutators", m.name)
+		}
+		mut.AddOMutator(fn)
+	}
+	return nil
+}
+
+// Register adds a MicroController to the stack. The 'requires' slice holds all Ads indexes the
+// MicroController needs to be present during its Apply call while 'provides' holds all indexes
+// the MicroController advertises once it is ready. Names must be unique within the stack.
+func (s *Stack) Register(name string, mc MicroController, requires, provides []string) error {
+	for _, m := range s.members {
+		if m.name == name {
+			return fmt.Errorf("micro controller %q already registered", name)
+		}
+	}
+
+	s.members = append(
+		s.members,
+		&member{
+			name:     name,
+			mctrl:    mc,
+			requires: requires,
+			provides: provides,
+		},
+	)
+	return nil
+}
+
+// Order returns the names of the registered MicroControllers in the order they must be applied
+// for a regular (non teardown) overlay. Returns an error if a required index is not provided by
+// any MicroController, if an index is provided by more than one MicroController or if there is a
+// dependency cycle among them. Registration order is kept among independent MicroControllers.
+func (s *Stack) Order() ([]string, error) {
+	members, err := s.sorted()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, m := range members {
+		names = append(names, m.name)
+	}
+	return names, nil
+}
+
+// sorted returns the registered members topologically sorted by their dependencies.
+func (s *Stack) sorted() ([]*member, error) {
+	providers := map[string]*member{}
+	for _, m := range s.members {
+		for _, idx := range m.provides {
+			if prev, ok := providers[idx]; ok {
+				return nil, fmt.Errorf(
+					"index %q provided by both %q and %q", idx, prev.name, m.name,
+				)
+			}
+			providers[idx] = m
+		}
+	}
+
+	// deps maps each member into the set of members it depends on.
+	deps := map[*member]map[*member]bool{}
+	for _, m := range s.members {
+		deps[m] = map[*member]bool{}
+
+		var missing []string
+		for _, idx := range m.requires {
+			prov, ok := providers[idx]
+			if !ok {
+				missing = append(missing, idx)
+				continue
+			}
+			if prov != m {
+				deps[m][prov] = true
+			}
+		}
+
+		if len(missing) > 0 {
+			return nil, fmt.Errorf(
+				"%q requires %s but no one provides it", m.name, strings.Join(missing, ","),
+			)
+		}
+	}
+
+	var sorted []*member
+	done := map[*member]bool{}
+	for len(sorted) < len(s.members) {
+		progress := false
+		for _, m := range s.members {
+			if done[m] || !allDone(deps[m], done) {
+				continue
+			}
+			sorted = append(sorted, m)
+			done[m] = true
+			progress = true
+		}
+
+		if progress {
+			continue
+		}
+
+		var cycle []string
+		for _, m := range s.members {
+			if !done[m] {
+				cycle = append(cycle, m.name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("dependency cycle among %s", strings.Join(cycle, ","))
+	}
+	return sorted, nil
+}
+
+// allDone returns true if all members in 'deps' are present in 'done'.
+func allDone(deps, done map[*member]bool) bool {
+	for dep := range deps {
+		if !done[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply moves all registered MicroControllers to the provided overlay. Dependencies are resolved
+// prior to touching the cluster so a broken stack fails early. For regular overlays each
+// MicroController is applied, waited until ready and then asked for its Ads, these Ads are then
+// passed along to the next MicroControllers. For teardown overlays the current Ads are collected
+// first and then MicroControllers are applied in the reverse order. Returns the Ads advertised
+// by the whole stack after the overlay has been applied, each index is recorded as provided by
+// the MicroController that advertised it.
+func (s *Stack) Apply(ctx context.Context, overlay string) (*Ads, error) {
+	overlays := map[string]string{}
+	for _, m := range s.members {
+		overlays[m.name] = overlay
+	}
+	return s.ApplyOverlays(ctx, overlays)
+}
+
+// ApplyOverlays moves each registered MicroController to its own overlay. The 'overlays' map is
+// indexed by the names used during Register. MicroControllers absent from the map are left
+// untouched, their current Ads are still passed along to their consumers. MicroControllers
+// moving to teardown overlays are handled first: they are applied in the reverse order with the
+// current Ads of the whole stack. Then the remaining MicroControllers are applied in order, as
+// in Apply. Returns the Ads advertised by the whole stack.
+func (s *Stack) ApplyOverlays(ctx context.Context, overlays map[string]string) (*Ads, error) {
+	ads := NewAds()
+
+	members, err := s.sorted()
+	if err != nil {
+		return ads, fmt.Errorf("invalid stack: %w", err)
+	}
+
+	overlayFor := func(m *member) string {
+		if overlay, ok := overlays[m.name]; ok && overlay != "" {
+			return overlay
+		}
+		return BaseOverlay
+	}
+
+	var teardown []*member
+	for _, m := range members {
+		if _, selected := overlays[m.name]; selected && s.teardowns[overlayFor(m)] {
+			teardown = append(teardown, m)
+		}
+	}
+
+	// collects the current ads of all untouched members and of all members being torn down,
+	// these are passed along to the members applied afterwards. Members being torn down may
+	// still consume ads from members about to be applied so, if there is any, they get the
+	// current ads of the whole stack.
+	current := NewAds()
+	for _, m := range members {
+		_, selected := overlays[m.name]
+		forward := selected && !s.teardowns[overlayFor(m)]
+		if forward && len(teardown) == 0 {
+			continue
+		}
+
+		mads, err := m.mctrl.Advertise(ctx)
+		if err != nil {
+			return ads, fmt.Errorf("error reading %q ads: %w", m.name, err)
+		}
+
+		if err := current.Merge(m.name, mads); err != nil {
+			return ads, err
+		}
+
+		if forward {
+			continue
+		}
+
+		if err := ads.Merge(m.name, mads); err != nil {
+			return ads, err
+		}
+	}
+
+	for i := len(teardown) - 1; i >= 0; i-- {
+		m := teardown[i]
+		if _, err := s.applyMember(ctx, m, overlayFor(m), current); err != nil {
+			return ads, err
+		}
+	}
+
+	for _, m := range members {
+		overlay := overlayFor(m)
+		if _, ok := overlays[m.name]; !ok || s.teardowns[overlay] {
+			continue
+		}
+
+		mads, err := s.applyMember(ctx, m, overlay, ads)
+		if err != nil {
+			return ads, err
+		}
+
+		if err := mads.Contains(m.provides...); err != nil {
+			return ads, fmt.Errorf("%q failed to advertise: %w", m.name, err)
+		}
+
+		if err := ads.Merge(m.name, mads); err != nil {
+			return ads, err
+		}
+	}
+	return ads, nil
+}
+
+// applyMember applies the overlay to a single member and waits until it reports itself ready.
+// Members up to date are not applied again, see SetSkipUpToDate. Returns the Ads advertised by
+// the member once ready.
+func (s *Stack) applyMember(
+	ctx context.Context, m *member, overlay string, ads *Ads,
+) (*Ads, error) {
+	skip, err := s.upToDate(ctx, m, overlay, ads)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting %q: %w", m.name, err)
+	}
+
+	if !skip {
+		if err := m.mctrl.Apply(ctx, overlay, ads); err != nil {
+			return nil, fmt.Errorf("error applying %q: %w", m.name, err)
+		}
+	}
+
+	if err := s.waitMember(ctx, m, overlay); err != nil {
+		return nil, err
+	}
+
+	mads, err := m.mctrl.Advertise(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q ads: %w", m.name, err)
+	}
+	return mads, nil
+}
+
+// upToDate returns true if skipping up to date members is enabled and the member is both up to
+// date with the overlay and ready. Members not implementing UpToDater are never up to date.
+func (s *Stack) upToDate(ctx context.Context, m *member, overlay string, ads *Ads) (bool, error) {
+	utd, ok := m.mctrl.(UpToDater)
+	if !s.skip || !ok {
+		return false, nil
+	}
+
+	if uptodate, err := utd.UpToDate(ctx, overlay, ads); err != nil || !uptodate {
+		return false, err
+	}
+
+	// failing to read the status is not fatal, the member is applied and waited as usual.
+	status, err := m.mctrl.Status(ctx)
+	if err != nil {
+		return false, nil
+	}
+	return status.Ready, nil
+}
+
+// waitMember waits until the member reports itself ready, see SetWaitOptions. The time it took
+// is recorded in the time to ready metric, members are identified by their Identified interface
+// or, if not implemented, by their names in the stack. Nothing is recorded when NoWait is set as
+// a single Status call says nothing about the time it took.
+func (s *Stack) waitMember(ctx context.Context, m *member, overlay string) error {
+	start := time.Now()
+	if err := WaitReady(ctx, m.mctrl, s.wait); err != nil {
+		return fmt.Errorf("error waiting for %q: %w", m.name, err)
+	}
+	if s.wait.NoWait {
+		return nil
+	}
+
+	namespace, name := "", m.name
+	if id, ok := m.mctrl.(Identified); ok {
+		namespace, name = id.Namespace(), id.Name()
+	}
+	timeToReady.WithLabelValues(namespace, name, overlay).Observe(time.Since(start).Seconds())
+	return nil
+}
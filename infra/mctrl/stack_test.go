@@ -0,0 +1,223 @@
+package mctrl_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+func TestStackOrder(t *testing.T) {
+	type member struct {
+		name     string
+		requires []string
+		provides []string
+	}
+
+	for _, tt := range []struct {
+		name    string
+		members []member
+		order   []string
+		err     string
+	}{
+		{
+			name: "dependencies first",
+			members: []member{
+				{name: "clair", requires: []string{"db"}, provides: []string{"clair"}},
+				{name: "quay", requires: []string{"db", "clair", "redis"}},
+				{name: "postgres", provides: []string{"db"}},
+				{name: "redis", provides: []string{"redis"}},
+			},
+			order: []string{"postgres", "redis", "clair", "quay"},
+		},
+		{
+			name: "registration order among independents",
+			members: []member{
+				{name: "b", provides: []string{"b"}},
+				{name: "a", provides: []string{"a"}},
+				{name: "c", requires: []string{"c"}, provides: []string{"c"}},
+			},
+			order: []string{"b", "a", "c"},
+		},
+		{
+			name: "duplicate provider",
+			members: []member{
+				{name: "postgres", provides: []string{"db"}},
+				{name: "another", provides: []string{"db"}},
+			},
+			err: `index "db" provided by both "postgres" and "another"`,
+		},
+		{
+			name: "missing provider",
+			members: []member{
+				{name: "clair", requires: []string{"db", "cache"}},
+			},
+			err: `"clair" requires db,cache but no one provides it`,
+		},
+		{
+			name: "cycle",
+			members: []member{
+				{name: "root", provides: []string{"root"}},
+				{name: "b", requires: []string{"root", "a"}, provides: []string{"b"}},
+				{name: "a", requires: []string{"b"}, provides: []string{"a"}},
+			},
+			err: "dependency cycle among a,b",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stack := mctrl.NewStack()
+			for _, m := range tt.members {
+				if err := stack.Register(m.name, nil, m.requires, m.provides); err != nil {
+					t.Fatalf("error registering %s: %s", m.name, err)
+				}
+			}
+
+			order, err := stack.Order()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, found %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(order, tt.order) {
+				t.Fatalf("order %v, expected %v", order, tt.order)
+			}
+		})
+	}
+}
+
+func TestStackRegisterTwice(t *testing.T) {
+	stack := mctrl.NewStack()
+	if err := stack.Register("postgres", nil, nil, nil); err != nil {
+		t.Fatalf("error registering: %s", err)
+	}
+	if err := stack.Register("postgres", nil, nil, nil); err == nil {
+		t.Fatal("registered the same name twice")
+	}
+}
+
+// member is a MicroController advertising a fixed set of Ads at any overlay but the scale down
+// one. Apply fails if any of the required indexes is missing from the received Ads.
+type member struct {
+	overlay  string
+	ads      map[string]string
+	requires []string
+}
+
+func (m *member) Apply(ctx context.Context, overlay string, ads *mctrl.Ads) error {
+	if err := ads.Contains(m.requires...); err != nil {
+		return fmt.Errorf("unable to apply %s: %w", overlay, err)
+	}
+	m.overlay = overlay
+	return nil
+}
+
+func (m *member) Advertise(context.Context) (*mctrl.Ads, error) {
+	ads := mctrl.NewAds()
+	if m.overlay == mctrl.ScaleDownOverlay {
+		return ads, nil
+	}
+	for idx, val := range m.ads {
+		ads.Put(idx, val)
+	}
+	return ads, nil
+}
+
+func (m *member) Overlay() string { return m.overlay }
+
+func (m *member) Status(context.Context) (*mctrl.Status, error) {
+	return &mctrl.Status{Ready: true}, nil
+}
+
+func TestStackApplyOverlays(t *testing.T) {
+	ctx := context.Background()
+	pgsql := &member{overlay: mctrl.BaseOverlay, ads: map[string]string{"db": "pgsql"}}
+	clair := &member{overlay: mctrl.BaseOverlay, requires: []string{"db"}}
+
+	stack := mctrl.NewStack()
+	if err := stack.Register("postgres", pgsql, nil, []string{"db"}); err != nil {
+		t.Fatalf("error registering postgres: %s", err)
+	}
+	if err := stack.Register("clair", clair, []string{"db"}, nil); err != nil {
+		t.Fatalf("error registering clair: %s", err)
+	}
+
+	// clair is torn down while postgres, its provider, is applied in the same call.
+	ads, err := stack.ApplyOverlays(
+		ctx, map[string]string{
+			"postgres": mctrl.BaseOverlay,
+			"clair":    mctrl.ScaleDownOverlay,
+		},
+	)
+	if err != nil {
+		t.Fatalf("error applying overlays: %s", err)
+	}
+	if clair.overlay != mctrl.ScaleDownOverlay {
+		t.Errorf("clair at %s, expected %s", clair.overlay, mctrl.ScaleDownOverlay)
+	}
+	if provider := ads.Provider("db"); provider != "postgres" {
+		t.Errorf("expected db provided by postgres, got %q", provider)
+	}
+
+	// postgres is left untouched, its current ads are still passed along to clair.
+	if _, err := stack.ApplyOverlays(
+		ctx, map[string]string{"clair": mctrl.BaseOverlay},
+	); err != nil {
+		t.Fatalf("error applying overlays: %s", err)
+	}
+	if clair.overlay != mctrl.BaseOverlay {
+		t.Errorf("clair at %s, expected %s", clair.overlay, mctrl.BaseOverlay)
+	}
+}
+
+// tracked is a member able to tell whether it is up to date, it counts the Apply calls.
+type tracked struct {
+	member
+	uptodate bool
+	applies  int
+}
+
+func (t *tracked) Apply(ctx context.Context, overlay string, ads *mctrl.Ads) error {
+	t.applies++
+	return t.member.Apply(ctx, overlay, ads)
+}
+
+func (t *tracked) UpToDate(context.Context, string, *mctrl.Ads) (bool, error) {
+	return t.uptodate, nil
+}
+
+func TestStackSkipUpToDate(t *testing.T) {
+	ctx := context.Background()
+	pgsql := &tracked{member: member{ads: map[string]string{"db": "pgsql"}}, uptodate: true}
+	clair := &tracked{member: member{requires: []string{"db"}}}
+
+	stack := mctrl.NewStack()
+	if err := stack.Register("postgres", pgsql, nil, []string{"db"}); err != nil {
+		t.Fatalf("error registering postgres: %s", err)
+	}
+	if err := stack.Register("clair", clair, []string{"db"}, nil); err != nil {
+		t.Fatalf("error registering clair: %s", err)
+	}
+
+	if _, err := stack.Apply(ctx, mctrl.BaseOverlay); err != nil {
+		t.Fatalf("error applying: %s", err)
+	}
+	if pgsql.applies != 1 || clair.applies != 1 {
+		t.Fatalf("expected one apply each, got %d and %d", pgsql.applies, clair.applies)
+	}
+
+	// up to date members are skipped, their ads are still passed along.
+	stack.SetSkipUpToDate(true)
+	if _, err := stack.Apply(ctx, mctrl.BaseOverlay); err != nil {
+		t.Fatalf("error applying: %s", err)
+	}
+	if pgsql.applies != 1 || clair.applies != 2 {
+		t.Fatalf("expected postgres skipped, got %d and %d", pgsql.applies, clair.applies)
+	}
+}
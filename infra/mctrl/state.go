@@ -0,0 +1,173 @@
+package mctrl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The following keys are used to store KustCtrl state inside its state secret. The last applied
+// overlay is kept under StateOverlayKey while StateDigestKey holds the digest of the objects
+// applied, see UpToDate.
+const (
+	StateOverlayKey = "overlay"
+	StateDigestKey  = "digest"
+)
+
+// Recoverer is implemented by MicroControllers that persist their state in the cluster so it can
+// be recovered by a different process. Only the state needed to query the cluster again is
+// persisted, e.g. the Ads are not, they are recomputed by Advertise once the state is recovered.
+type Recoverer interface {
+	Recover(ctx context.Context) error
+}
+
+// UpToDater is implemented by MicroControllers able to tell whether applying an overlay would
+// change what they last applied, see Stack.SetSkipUpToDate.
+type UpToDater interface {
+	UpToDate(ctx context.Context, overlay string, ads *Ads) (bool, error)
+}
+
+// stateName returns the name of the secret used to store the controller state.
+func (k *KustCtrl) stateName() string {
+	return fmt.Sprintf("%s-state", k.name)
+}
+
+// Recover reads the persisted state from the cluster, restoring the last applied overlay, the
+// digest of its objects and the list of applied objects (from the inventory). This allows a new process to query (Status,
+// Advertise) or continue a stack deployed by a different process. If no state has been persisted
+// yet this is a no-op. Recover is a no-op for controllers without identity as well.
+func (k *KustCtrl) Recover(ctx context.Context) error {
+	if k.name == "" {
+		return nil
+	}
+
+	nsn := types.NamespacedName{
+		Namespace: k.namespace,
+		Name:      k.stateName(),
+	}
+
+	var sct corev1.Secret
+	if err := k.cli.Get(ctx, nsn, &sct); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading state: %w", err)
+	}
+
+	applied, err := k.loadInventory(ctx)
+	if err != nil {
+		return err
+	}
+
+	overlay := string(sct.Data[StateOverlayKey])
+	k.setOverlayMetric(overlay)
+	k.setApplied(overlay, applied, string(sct.Data[StateDigestKey]))
+	k.Logger(ctx).V(1).Info("state recovered", LogKeyOverlay, overlay)
+	return nil
+}
+
+// UpToDate returns true if the overlay is the last applied one and rendering it with the
+// provided Ads results in the same objects last applied. Nothing is written to the cluster.
+// Changes made to the objects in the cluster by someone else are not taken into account.
+func (k *KustCtrl) UpToDate(ctx context.Context, overlay string, ads *Ads) (bool, error) {
+	current, applied := k.appliedDigest()
+	if current != overlay || applied == "" {
+		return false, nil
+	}
+
+	objs, err := k.Render(ctx, overlay, ads)
+	if err != nil {
+		return false, err
+	}
+
+	rendered, err := digest(objs)
+	if err != nil {
+		return false, err
+	}
+	return rendered == applied, nil
+}
+
+// digest returns the sha256 digest of the objects json representation, in order.
+func digest(objs []client.Object) (string, error) {
+	hash := sha256.New()
+	for _, obj := range objs {
+		dt, err := json.Marshal(obj)
+		if err != nil {
+			return "", fmt.Errorf("error marshaling %s: %w", RefFor(obj), err)
+		}
+		hash.Write(dt)
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+// storeState persists the current overlay and the digest of its objects in the state secret.
+// The secret goes through all registered OMutators before being applied. This is a no-op for
+// controllers without identity.
+func (k *KustCtrl) storeState(ctx context.Context) error {
+	if k.name == "" {
+		return nil
+	}
+
+	overlay, dgst := k.appliedDigest()
+	sct := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      k.stateName(),
+			Namespace: k.namespace,
+		},
+		Data: map[string][]byte{
+			StateOverlayKey: []byte(overlay),
+			StateDigestKey:  []byte(dgst),
+		},
+	}
+
+	for _, mut := range k.OMutators {
+		if err := mut(ctx, sct); err != nil {
+			return fmt.Errorf("error mutating state: %w", err)
+		}
+	}
+
+	if err := k.patch(ctx, sct); err != nil {
+		return fmt.Errorf("error patching state: %w", err)
+	}
+	return nil
+}
+
+// Recover recovers the state of all registered MicroControllers implementing the Recoverer
+// interface and returns the Ads currently advertised by the whole stack.
+func (s *Stack) Recover(ctx context.Context) (*Ads, error) {
+	ads := NewAds()
+
+	members, err := s.sorted()
+	if err != nil {
+		return ads, fmt.Errorf("invalid stack: %w", err)
+	}
+
+	for _, m := range members {
+		if rec, ok := m.mctrl.(Recoverer); ok {
+			if err := rec.Recover(ctx); err != nil {
+				return ads, fmt.Errorf("error recovering %q: %w", m.name, err)
+			}
+		}
+
+		mads, err := m.mctrl.Advertise(ctx)
+		if err != nil {
+			return ads, fmt.Errorf("error reading %q ads: %w", m.name, err)
+		}
+
+		if err := ads.Merge(m.name, mads); err != nil {
+			return ads, err
+		}
+	}
+	return ads, nil
+}
@@ -0,0 +1,112 @@
+package mctrl_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+	"github.com/ricardomaraschini/freighter/infra/resource"
+)
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+	cli := mctrltest.NewClient(resource.Scheme)
+	apply(t, newKustCtrl(cli), "extra")
+
+	// ads are not persisted, they are recomputed once the state is recovered.
+	var sct corev1.Secret
+	nsn := types.NamespacedName{Namespace: "test", Name: "app-state"}
+	if err := cli.Get(ctx, nsn, &sct); err != nil {
+		t.Fatalf("error reading state: %s", err)
+	}
+	var keys []string
+	for key := range sct.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if expected := []string{mctrl.StateDigestKey, mctrl.StateOverlayKey}; !reflect.DeepEqual(
+		keys, expected,
+	) {
+		t.Fatalf("state holds %v, expected %v", keys, expected)
+	}
+	if overlay := string(sct.Data[mctrl.StateOverlayKey]); overlay != "extra" {
+		t.Fatalf("persisted overlay %q, expected extra", overlay)
+	}
+
+	k := newKustCtrl(cli)
+	if err := k.Recover(ctx); err != nil {
+		t.Fatalf("error recovering: %s", err)
+	}
+	if overlay := k.Overlay(); overlay != "extra" {
+		t.Fatalf("recovered overlay %q, expected extra", overlay)
+	}
+
+	// the recovered inventory allows the new instance to prune what the first one applied.
+	apply(t, k, mctrl.BaseOverlay)
+	assertInventory(t, cli, "a")
+	assertExists(t, cli, "b", false)
+}
+
+func TestRecoverWithoutState(t *testing.T) {
+	k := newKustCtrl(mctrltest.NewClient(resource.Scheme))
+	if err := k.Recover(context.Background()); err != nil {
+		t.Fatalf("error recovering: %s", err)
+	}
+	if overlay := k.Overlay(); overlay != mctrl.NotAppliedOverlay {
+		t.Fatalf("recovered overlay %q without state", overlay)
+	}
+}
+
+// assertUpToDate expects the controller to be, or not, up to date with the overlay.
+func assertUpToDate(t *testing.T, k *mctrl.KustCtrl, overlay string, expected bool) {
+	t.Helper()
+	uptodate, err := k.UpToDate(context.Background(), overlay, mctrl.NewAds())
+	if err != nil {
+		t.Fatalf("error checking %s: %s", overlay, err)
+	}
+	if uptodate != expected {
+		t.Fatalf("up to date with %s is %v, expected %v", overlay, uptodate, expected)
+	}
+}
+
+func TestUpToDate(t *testing.T) {
+	ctx := context.Background()
+	cli := mctrltest.NewClient(resource.Scheme)
+	k := newKustCtrl(cli)
+
+	assertUpToDate(t, k, mctrl.BaseOverlay, false)
+	apply(t, k, "extra")
+	assertUpToDate(t, k, "extra", true)
+	assertUpToDate(t, k, mctrl.BaseOverlay, false)
+
+	// the digest survives a recover.
+	recovered := newKustCtrl(cli)
+	if err := recovered.Recover(ctx); err != nil {
+		t.Fatalf("error recovering: %s", err)
+	}
+	assertUpToDate(t, recovered, "extra", true)
+
+	// objects rendered differently are not up to date.
+	recovered.AddOMutator(func(ctx context.Context, obj client.Object) error {
+		obj.SetLabels(map[string]string{"changed": "true"})
+		return nil
+	})
+	assertUpToDate(t, recovered, "extra", false)
+
+	// after a partial apply the cluster no longer holds what was last applied.
+	failing := newKustCtrl(&failingClient{Client: cli, name: "c"})
+	if err := failing.Recover(ctx); err != nil {
+		t.Fatalf("error recovering: %s", err)
+	}
+	if err := failing.Apply(ctx, "broken", mctrl.NewAds()); err == nil {
+		t.Fatal("expected broken overlay to fail")
+	}
+	assertUpToDate(t, failing, "extra", false)
+}
@@ -0,0 +1,137 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RollbackError is returned by a transactional Apply when patching one of the objects fails. It
+// wraps the original error and informs what objects were restored to their previous state, what
+// objects were deleted (did not exist before the Apply call) and what objects could not be
+// rolled back at all.
+type RollbackError struct {
+	Err      error
+	Restored []ObjectRef
+	Deleted  []ObjectRef
+	Failed   map[ObjectRef]error
+}
+
+// Error returns a summary of the original error and of the rollback outcome.
+func (r *RollbackError) Error() string {
+	msg := fmt.Sprintf(
+		"%s (rolled back: %d restored, %d deleted", r.Err, len(r.Restored), len(r.Deleted),
+	)
+	if len(r.Failed) == 0 {
+		return msg + ")"
+	}
+
+	var failed []string
+	for ref, err := range r.Failed {
+		failed = append(failed, fmt.Sprintf("%s: %s", ref, err))
+	}
+	return fmt.Sprintf("%s, failed to roll back %s)", msg, strings.Join(failed, ", "))
+}
+
+// Unwrap returns the original error, the one that triggered the rollback.
+func (r *RollbackError) Unwrap() error {
+	return r.Err
+}
+
+// WithTransaction enables the transactional mode. In this mode the live state of all objects
+// touched by an overlay is read prior to Apply, if Apply fails at some point all objects already
+// patched are restored to their previous state. Objects that did not exist are deleted instead.
+// Pruning happens only after all objects have been patched and is not rolled back.
+func WithTransaction() KustOption {
+	return func(k *KustCtrl) {
+		k.transactional = true
+	}
+}
+
+// snapshot reads the live state of all referred objects. Returns a map indexed by reference, if
+// an object does not exist in the cluster it is not present in the returned map.
+func (k *KustCtrl) snapshot(
+	ctx context.Context, refs []ObjectRef,
+) (map[ObjectRef]*unstructured.Unstructured, error) {
+	snaps := map[ObjectRef]*unstructured.Unstructured{}
+	for _, ref := range refs {
+		obj := ref.Unstructured()
+		nsn := types.NamespacedName{
+			Namespace: ref.Namespace,
+			Name:      ref.Name,
+		}
+
+		if err := k.cli.Get(ctx, nsn, obj); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("error reading %s: %w", ref, err)
+		}
+		snaps[ref] = obj
+	}
+	return snaps, nil
+}
+
+// rollback undoes the changes made to the objects referred by 'patched'. Objects present in the
+// snapshot are updated back to their previous content while the ones absent are deleted. This
+// function always returns a RollbackError wrapping 'cause'.
+func (k *KustCtrl) rollback(
+	ctx context.Context,
+	snaps map[ObjectRef]*unstructured.Unstructured,
+	patched []ObjectRef,
+	cause error,
+) error {
+	rberr := &RollbackError{
+		Err:    cause,
+		Failed: map[ObjectRef]error{},
+	}
+
+	for i := len(patched) - 1; i >= 0; i-- {
+		ref := patched[i]
+
+		snap, existed := snaps[ref]
+		if !existed {
+			err := k.cli.Delete(ctx, ref.Unstructured())
+			if err != nil && !errors.IsNotFound(err) {
+				rberr.Failed[ref] = err
+				continue
+			}
+			rberr.Deleted = append(rberr.Deleted, ref)
+			continue
+		}
+
+		if err := k.restore(ctx, ref, snap); err != nil {
+			rberr.Failed[ref] = err
+			continue
+		}
+		rberr.Restored = append(rberr.Restored, ref)
+	}
+	return rberr
+}
+
+// restore updates the referred object with the content of the provided snapshot. The snapshot
+// is updated using the current object resource version.
+func (k *KustCtrl) restore(
+	ctx context.Context, ref ObjectRef, snap *unstructured.Unstructured,
+) error {
+	cur := ref.Unstructured()
+	nsn := types.NamespacedName{
+		Namespace: ref.Namespace,
+		Name:      ref.Name,
+	}
+
+	if err := k.cli.Get(ctx, nsn, cur); err != nil {
+		return fmt.Errorf("error reading object: %w", err)
+	}
+
+	snap = snap.DeepCopy()
+	snap.SetResourceVersion(cur.GetResourceVersion())
+	if err := k.cli.Update(ctx, snap); err != nil {
+		return fmt.Errorf("error updating object: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package mctrl_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+	"github.com/ricardomaraschini/freighter/infra/mctrl/mctrltest"
+	"github.com/ricardomaraschini/freighter/infra/resource"
+)
+
+func TestRollback(t *testing.T) {
+	ctx := context.Background()
+	existing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "a"},
+		Data:       map[string]string{"key": "previous"},
+	}
+	cli := mctrltest.NewClient(resource.Scheme, existing)
+	k := newKustCtrl(&failingClient{Client: cli, name: "c"}, mctrl.WithTransaction())
+
+	err := k.Apply(ctx, "broken", mctrl.NewAds())
+
+	var rberr *mctrl.RollbackError
+	if !errors.As(err, &rberr) {
+		t.Fatalf("expected rollback error, found %v", err)
+	}
+	if !errors.Is(err, errPatch) {
+		t.Fatalf("rollback error does not wrap the patch error: %s", err)
+	}
+	if !reflect.DeepEqual(rberr.Restored, []mctrl.ObjectRef{ref("a")}) {
+		t.Fatalf("restored %v, expected a", rberr.Restored)
+	}
+	if !reflect.DeepEqual(rberr.Deleted, []mctrl.ObjectRef{ref("b")}) {
+		t.Fatalf("deleted %v, expected b", rberr.Deleted)
+	}
+	if len(rberr.Failed) > 0 {
+		t.Fatalf("failed to roll back: %v", rberr.Failed)
+	}
+	if overlay := k.Overlay(); overlay != mctrl.NotAppliedOverlay {
+		t.Fatalf("rolled back controller at overlay %q", overlay)
+	}
+
+	var cm corev1.ConfigMap
+	if err := cli.Get(ctx, types.NamespacedName{Namespace: "test", Name: "a"}, &cm); err != nil {
+		t.Fatalf("error reading restored config map: %s", err)
+	}
+	if cm.Data["key"] != "previous" {
+		t.Fatalf("config map not restored, data: %v", cm.Data)
+	}
+	assertExists(t, cli, "b", false)
+	assertExists(t, cli, "c", false)
+}
@@ -0,0 +1,169 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// WaitOptions holds the options used by WaitReady. The zero value is valid and means: start
+// polling every second, double the interval after every poll up to 30 seconds, wait forever
+// (or until the context is done) and tolerate the errors IsTransient considers transient.
+type WaitOptions struct {
+	// Interval is the initial interval between two Status calls.
+	Interval time.Duration
+	// MaxInterval caps the interval between two Status calls.
+	MaxInterval time.Duration
+	// Factor multiplies the interval after each poll. Values lower than 1 mean 2.
+	Factor float64
+	// Timeout is the overall deadline, zero means no deadline.
+	Timeout time.Duration
+	// Transient decides if an error returned by Status is transient. Transient errors do not
+	// abort the wait. Defaults to IsTransient.
+	Transient func(error) bool
+	// Progress, if set, is called with every Status returned while waiting.
+	Progress func(*Status)
+	// NoWait makes WaitReady read the Status only once, if the MicroController is not ready
+	// a NotReadyError is returned right away. Meant for reconcilers, they should requeue
+	// instead of blocking a worker.
+	NoWait bool
+}
+
+// withDefaults returns a copy of the options with defaults in place of unset values.
+func (w WaitOptions) withDefaults() WaitOptions {
+	if w.Interval <= 0 {
+		w.Interval = time.Second
+	}
+	if w.MaxInterval <= 0 {
+		w.MaxInterval = 30 * time.Second
+	}
+	if w.MaxInterval < w.Interval {
+		w.MaxInterval = w.Interval
+	}
+	if w.Factor < 1 {
+		w.Factor = 2
+	}
+	if w.Transient == nil {
+		w.Transient = IsTransient
+	}
+	return w
+}
+
+// WaitTimeoutError is returned by WaitReady when the MicroController does not become ready
+// before the deadline (or before the context is done). It carries the last Status returned by
+// the MicroController (nil if no Status has been successfully read), the last transient error
+// seen after that Status, if any, and the context error that ended the wait.
+type WaitTimeoutError struct {
+	Status  *Status
+	LastErr error
+	Err     error
+}
+
+// Error returns a description of the timeout including the last status and its conditions.
+func (w *WaitTimeoutError) Error() string {
+	msg := fmt.Sprintf("timeout waiting for readiness: %s", w.Err)
+	if w.LastErr != nil {
+		msg = fmt.Sprintf("%s, last error: %s", msg, w.LastErr)
+	}
+	if w.Status == nil {
+		return msg
+	}
+
+	msg = fmt.Sprintf("%s, last status: %s", msg, w.Status.Message)
+	var conds []string
+	for _, cond := range w.Status.Conditions {
+		conds = append(conds, fmt.Sprintf("%s=%s (%s)", cond.Type, cond.Status, cond.Message))
+	}
+	if len(conds) > 0 {
+		msg = fmt.Sprintf("%s, conditions: %s", msg, strings.Join(conds, ", "))
+	}
+	return msg
+}
+
+// Unwrap returns the error that ended the wait.
+func (w *WaitTimeoutError) Unwrap() error {
+	return w.Err
+}
+
+// NotReadyError is returned by WaitReady, when NoWait is set, if the MicroController is not
+// ready. It carries the Status returned by the MicroController.
+type NotReadyError struct {
+	Status *Status
+}
+
+// Error returns a description of why the MicroController is not ready.
+func (n *NotReadyError) Error() string {
+	return fmt.Sprintf("not ready: %s", n.Status.Message)
+}
+
+// IsTransient returns true if the error is one worth retrying, e.g. timeouts, throttling or
+// server side errors.
+func IsTransient(err error) bool {
+	return apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsInternalError(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsUnexpectedServerError(err)
+}
+
+// WaitReady polls the MicroController Status until it reports itself ready. The interval between
+// polls grows exponentially according to the options. Transient errors are tolerated while any
+// other error returned by Status aborts the wait. If the deadline is reached, or the context is
+// done, a WaitTimeoutError is returned. If NoWait is set the Status is read only once and a
+// NotReadyError is returned if the MicroController is not ready.
+func WaitReady(ctx context.Context, mc MicroController, opts WaitOptions) error {
+	opts = opts.withDefaults()
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var last *Status
+	var lasterr error
+	interval := opts.Interval
+	for {
+		status, err := mc.Status(ctx)
+		switch {
+		case err == nil:
+			last, lasterr = status, nil
+			if opts.Progress != nil {
+				opts.Progress(status)
+			}
+			if status.Ready {
+				return nil
+			}
+		case ctx.Err() != nil:
+			return &WaitTimeoutError{Status: last, LastErr: err, Err: ctx.Err()}
+		case opts.Transient(err):
+			lasterr = err
+		default:
+			return fmt.Errorf("error reading status: %w", err)
+		}
+
+		if opts.NoWait {
+			if lasterr != nil {
+				return fmt.Errorf("error reading status: %w", lasterr)
+			}
+			return &NotReadyError{Status: last}
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &WaitTimeoutError{Status: last, LastErr: lasterr, Err: ctx.Err()}
+		case <-timer.C:
+		}
+
+		interval = time.Duration(float64(interval) * opts.Factor)
+		if interval > opts.MaxInterval {
+			interval = opts.MaxInterval
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package mctrl_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/ricardomaraschini/freighter/infra/mctrl"
+)
+
+// result is a single Status call outcome.
+type result struct {
+	status *mctrl.Status
+	err    error
+}
+
+// scripted is a MicroController whose Status returns the scripted results in order, the last
+// one is repeated once the script is over.
+type scripted struct {
+	results []result
+	calls   int
+}
+
+func (s *scripted) Apply(context.Context, string, *mctrl.Ads) error { return nil }
+func (s *scripted) Advertise(context.Context) (*mctrl.Ads, error)   { return mctrl.NewAds(), nil }
+func (s *scripted) Overlay() string                                 { return mctrl.BaseOverlay }
+func (s *scripted) Status(ctx context.Context) (*mctrl.Status, error) {
+	res := s.results[len(s.results)-1]
+	if s.calls < len(s.results) {
+		res = s.results[s.calls]
+	}
+	s.calls++
+	return res.status, res.err
+}
+
+var (
+	ready      = result{status: &mctrl.Status{Ready: true, Message: "ready"}}
+	notReady   = result{status: &mctrl.Status{Message: "rolling out"}}
+	transient  = result{err: apierrors.NewServiceUnavailable("unavailable")}
+	fast       = mctrl.WaitOptions{Interval: time.Millisecond, MaxInterval: time.Millisecond}
+	permission = apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "p", nil)
+)
+
+func TestWaitReady(t *testing.T) {
+	mc := &scripted{results: []result{transient, notReady, transient, ready}}
+
+	var progress int
+	opts := fast
+	opts.Progress = func(*mctrl.Status) { progress++ }
+	if err := mctrl.WaitReady(context.Background(), mc, opts); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if mc.calls != 4 {
+		t.Fatalf("expected 4 status calls, found %d", mc.calls)
+	}
+	if progress != 2 {
+		t.Fatalf("expected progress to be reported twice, found %d", progress)
+	}
+}
+
+func TestWaitReadyAborts(t *testing.T) {
+	mc := &scripted{results: []result{notReady, {err: permission}, ready}}
+
+	err := mctrl.WaitReady(context.Background(), mc, fast)
+	if !apierrors.IsForbidden(err) {
+		t.Fatalf("expected forbidden error, found %v", err)
+	}
+	if mc.calls != 2 {
+		t.Fatalf("expected 2 status calls, found %d", mc.calls)
+	}
+}
+
+func TestWaitReadyTimeout(t *testing.T) {
+	mc := &scripted{results: []result{notReady, transient}}
+
+	opts := fast
+	opts.Timeout = 50 * time.Millisecond
+	err := mctrl.WaitReady(context.Background(), mc, opts)
+
+	var terr *mctrl.WaitTimeoutError
+	if !errors.As(err, &terr) {
+		t.Fatalf("expected timeout error, found %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("timeout error does not wrap the deadline: %s", err)
+	}
+	if terr.Status != notReady.status {
+		t.Fatalf("timeout error without the last status: %+v", terr.Status)
+	}
+	if !apierrors.IsServiceUnavailable(terr.LastErr) {
+		t.Fatalf("timeout error without the last transient error: %v", terr.LastErr)
+	}
+}
+
+func TestWaitReadyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := mctrl.WaitReady(ctx, &scripted{results: []result{notReady}}, fast)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, found %v", err)
+	}
+}
+
+func TestWaitReadyNoWait(t *testing.T) {
+	opts := mctrl.WaitOptions{NoWait: true}
+
+	mc := &scripted{results: []result{notReady, ready}}
+	err := mctrl.WaitReady(context.Background(), mc, opts)
+
+	var nerr *mctrl.NotReadyError
+	if !errors.As(err, &nerr) || nerr.Status != notReady.status {
+		t.Fatalf("expected not ready error, found %v", err)
+	}
+	if mc.calls != 1 {
+		t.Fatalf("expected a single status call, found %d", mc.calls)
+	}
+
+	mc = &scripted{results: []result{transient}}
+	if err := mctrl.WaitReady(context.Background(), mc, opts); !mctrl.IsTransient(
+		errors.Unwrap(err),
+	) {
+		t.Fatalf("expected transient error, found %v", err)
+	}
+
+	mc = &scripted{results: []result{ready}}
+	if err := mctrl.WaitReady(context.Background(), mc, opts); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	gr := schema.GroupResource{Resource: "pods"}
+	for _, tt := range []struct {
+		err       error
+		transient bool
+	}{
+		{err: apierrors.NewServerTimeout(gr, "get", 1), transient: true},
+		{err: apierrors.NewTimeoutError("timeout", 1), transient: true},
+		{err: apierrors.NewTooManyRequests("throttled", 1), transient: true},
+		{err: apierrors.NewInternalError(errors.New("boom")), transient: true},
+		{err: apierrors.NewServiceUnavailable("unavailable"), transient: true},
+		{err: fmt.Errorf("wrapped: %w", apierrors.NewServiceUnavailable("x")), transient: true},
+		{err: apierrors.NewNotFound(gr, "p")},
+		{err: permission},
+		{err: errors.New("unknown")},
+	} {
+		if got := mctrl.IsTransient(tt.err); got != tt.transient {
+			t.Errorf("IsTransient(%v) = %t, expected %t", tt.err, got, tt.transient)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package mctrl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// InstanceLabel is the label KustCtrl adds to all objects it renders (and to their pod templates)
+// when an identity is set. Its value is the controller name, see SetIdentity. This label allows
+// us to scope watches to the objects owned by a single controller instance.
+const InstanceLabel = "freighter.io/instance"
+
+// setInstanceLabel adds the InstanceLabel to the object and, if the object has a pod template,
+// to its pod template as well. This is a no-op for controllers without identity.
+func (k *KustCtrl) setInstanceLabel(obj client.Object) error {
+	if k.name == "" {
+		return nil
+	}
+
+	lbls := obj.GetLabels()
+	if lbls == nil {
+		lbls = map[string]string{}
+	}
+	lbls[InstanceLabel] = k.name
+	obj.SetLabels(lbls)
+
+	if uobj, ok := obj.(*unstructured.Unstructured); ok {
+		return k.setTemplateLabel(uobj.Object)
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return fmt.Errorf("error converting object: %w", err)
+	}
+
+	if _, found, _ := unstructured.NestedMap(content, "spec", "template"); !found {
+		return nil
+	}
+
+	if err := k.setTemplateLabel(content); err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(content, obj)
+}
+
+// setTemplateLabel sets the InstanceLabel in spec.template.metadata.labels if the provided
+// content contains a spec.template.
+func (k *KustCtrl) setTemplateLabel(content map[string]interface{}) error {
+	if _, found, _ := unstructured.NestedMap(content, "spec", "template"); !found {
+		return nil
+	}
+	path := []string{"spec", "template", "metadata", "labels", InstanceLabel}
+	return unstructured.SetNestedField(content, k.name, path...)
+}
+
+// statusWatch holds the state of the cache backed status mode. Notifications come from several
+// informer goroutines, mtx serializes them (see notify) and guards the tracked kinds.
+type statusWatch struct {
+	mtx      sync.Mutex
+	ctx      context.Context
+	cache    cache.Cache
+	status   func(context.Context) (*Status, error)
+	onChange func(*Status)
+	kinds    map[schema.GroupVersionKind]bool
+	ready    *bool
+}
+
+// Reader returns the reader used when computing the status. This is the cache when in cache
+// backed status mode (see WatchStatus) or the client otherwise.
+func (k *KustCtrl) Reader() client.Reader {
+	if k.watch == nil {
+		return k.cli
+	}
+	return k.watch.cache
+}
+
+// WatchStatus switches the controller into the cache backed status mode. In this mode a cache,
+// scoped to the controller namespace and to the objects carrying its InstanceLabel, is kept in
+// sync through watches and Status reads from it instead of issuing requests against the API
+// server. Pods and replica sets carrying the label are cached as well. On every change 'status'
+// is called to compute the Status, controllers embedding KustCtrl pass their own Status so
+// their extra checks are honored. Every time the readiness changes 'onChange' is called with
+// the new Status, it is also called once with the initial Status. Calls to 'status' and
+// 'onChange' are serialized. The cache is stopped when the context is done. Requires the
+// controller to have an identity and to have been applied or recovered first.
+func (k *KustCtrl) WatchStatus(
+	ctx context.Context,
+	cfg *rest.Config,
+	status func(context.Context) (*Status, error),
+	onChange func(*Status),
+) error {
+	if k.name == "" {
+		return fmt.Errorf("controller without identity")
+	}
+	if k.Overlay() == NotAppliedOverlay {
+		return fmt.Errorf("no overlay applied to the controller")
+	}
+
+	sel := labels.SelectorFromSet(labels.Set{InstanceLabel: k.name})
+	pod := &corev1.Pod{}
+	selectors := cache.SelectorsByObject{
+		pod:                  {Label: sel},
+		&appsv1.ReplicaSet{}: {Label: sel},
+	}
+
+	// the selector type is internal to the cache package so we reuse the one built for pods.
+	for _, gvk := range k.appliedKinds() {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		selectors[obj] = selectors[pod]
+	}
+
+	ch, err := cache.New(
+		cfg,
+		cache.Options{
+			Scheme:            k.cli.Scheme(),
+			Mapper:            k.cli.RESTMapper(),
+			Namespace:         k.namespace,
+			SelectorsByObject: selectors,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating cache: %w", err)
+	}
+
+	go func() {
+		_ = ch.Start(ctx)
+	}()
+
+	if !ch.WaitForCacheSync(ctx) {
+		return fmt.Errorf("error syncing cache")
+	}
+
+	k.watch = &statusWatch{
+		ctx:      ctx,
+		cache:    ch,
+		status:   status,
+		onChange: onChange,
+		kinds:    map[schema.GroupVersionKind]bool{},
+	}
+
+	podgvk := corev1.SchemeGroupVersion.WithKind("Pod")
+	if err := k.trackKinds(ctx, append(k.appliedKinds(), podgvk)); err != nil {
+		return err
+	}
+
+	k.watch.notify(ctx)
+	return nil
+}
+
+// appliedKinds returns the group version kinds of all applied objects.
+func (k *KustCtrl) appliedKinds() []schema.GroupVersionKind {
+	seen := map[schema.GroupVersionKind]bool{}
+	var gvks []schema.GroupVersionKind
+	for _, ref := range k.appliedRefs() {
+		gvk := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind)
+		if seen[gvk] {
+			continue
+		}
+		seen[gvk] = true
+		gvks = append(gvks, gvk)
+	}
+	return gvks
+}
+
+// trackKinds makes sure we are notified about changes on objects of the provided kinds. Kinds
+// tracked for the first time after WatchStatus has been called are scoped to the namespace only.
+// Notifications are bound to the context provided to WatchStatus.
+func (k *KustCtrl) trackKinds(ctx context.Context, gvks []schema.GroupVersionKind) error {
+	if k.watch == nil {
+		return nil
+	}
+
+	for _, gvk := range gvks {
+		k.watch.mtx.Lock()
+		tracked := k.watch.kinds[gvk]
+		k.watch.kinds[gvk] = true
+		k.watch.mtx.Unlock()
+
+		if tracked {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		inf, err := k.watch.cache.GetInformer(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("error getting informer for %s: %w", gvk, err)
+		}
+
+		inf.AddEventHandler(
+			toolscache.ResourceEventHandlerFuncs{
+				AddFunc:    func(interface{}) { k.watch.notify(k.watch.ctx) },
+				UpdateFunc: func(interface{}, interface{}) { k.watch.notify(k.watch.ctx) },
+				DeleteFunc: func(interface{}) { k.watch.notify(k.watch.ctx) },
+			},
+		)
+	}
+	return nil
+}
+
+// notify computes the current status and calls the registered callback if the readiness has
+// changed since the last notification. The lock is held throughout so a status computed later
+// is never reported before, or instead of, one computed earlier.
+func (w *statusWatch) notify(ctx context.Context) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	status, err := w.status(ctx)
+	if err != nil {
+		return
+	}
+
+	changed := w.ready == nil || *w.ready != status.Ready
+	w.ready = &status.Ready
+	if changed && w.onChange != nil {
+		w.onChange(status)
+	}
+}
@@ -0,0 +1,71 @@
+package mctrl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatusWatchNotify(t *testing.T) {
+	var mtx sync.Mutex
+	var calls int
+	var busy, overlap bool
+	var reported []bool
+
+	watch := &statusWatch{
+		status: func(context.Context) (*Status, error) {
+			mtx.Lock()
+			overlap = overlap || busy
+			busy = true
+			calls++
+			call := calls
+			mtx.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mtx.Lock()
+			busy = false
+			mtx.Unlock()
+
+			if call%5 == 0 {
+				return nil, errors.New("transient")
+			}
+			return &Status{Ready: call%3 == 0}, nil
+		},
+		onChange: func(status *Status) {
+			reported = append(reported, status.Ready)
+		},
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watch.notify(ctx)
+		}()
+	}
+	wg.Wait()
+
+	if overlap {
+		t.Fatal("status computed concurrently")
+	}
+	if len(reported) == 0 || reported[0] {
+		t.Fatalf("expected the initial not ready status to be reported, got %v", reported)
+	}
+	for i := 1; i < len(reported); i++ {
+		if reported[i] == reported[i-1] {
+			t.Fatalf("readiness reported without a change: %v", reported)
+		}
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	watch.notify(canceled)
+	if calls != 30 {
+		t.Fatalf("expected 30 status calls, got %d", calls)
+	}
+}
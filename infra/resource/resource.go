@@ -4,13 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 
-	appsv1 "k8s.io/api/apps/v1"
-	asclv1 "k8s.io/api/autoscaling/v1"
-	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/kustomize/api/resource"
-	"sigs.k8s.io/kustomize/kyaml/resid"
 )
 
 // ToCondition attempts to convert any information into a metav1.Condition. Use this function
@@ -32,58 +33,63 @@ func ToCondition(in interface{}) (metav1.Condition, error) {
 	return cond, nil
 }
 
-// ToObject converts provided resource.Resource into a client.Object representation by marshaling
-// and unmarshaling into a kubernetes struct. This function will return an error if Resource GVK
-// is not mapped to a struct.
-func ToObject(res *resource.Resource) (client.Object, error) {
-	var obj client.Object
-
-	switch res.GetGvk() {
-	case resid.Gvk{
-		Version: "v1",
-		Kind:    "Secret",
-	}:
-		obj = &corev1.Secret{}
+// Scheme is the scheme used by ToObject to resolve types. It holds all kubernetes built-in types
+// by default, callers can extend it through AddToScheme.
+var Scheme = runtime.NewScheme()
 
-	case resid.Gvk{
-		Version: "v1",
-		Kind:    "Service",
-	}:
-		obj = &corev1.Service{}
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(Scheme))
+}
 
-	case resid.Gvk{
-		Version: "v1",
-		Kind:    "ServiceAccount",
-	}:
-		obj = &corev1.ServiceAccount{}
+// AddToScheme registers additional types (e.g. custom resources) in the default Scheme.
+func AddToScheme(fns ...func(*runtime.Scheme) error) error {
+	for _, fn := range fns {
+		if err := fn(Scheme); err != nil {
+			return fmt.Errorf("error adding to scheme: %w", err)
+		}
+	}
+	return nil
+}
 
-	case resid.Gvk{
-		Version: "v1",
-		Kind:    "PersistentVolumeClaim",
-	}:
-		obj = &corev1.PersistentVolumeClaim{}
+// ToObject converts provided resource.Resource into a client.Object representation using the
+// default Scheme, see ToObjectWithScheme.
+func ToObject(res *resource.Resource) (client.Object, error) {
+	return ToObjectWithScheme(res, Scheme)
+}
 
-	case resid.Gvk{
-		Group:   "apps",
-		Version: "v1",
-		Kind:    "Deployment",
-	}:
-		obj = &appsv1.Deployment{}
+// ToObjectWithScheme converts provided resource.Resource into a client.Object representation by
+// marshaling and unmarshaling into a kubernetes struct. The struct is resolved through provided
+// scheme, kinds not registered in the scheme (e.g. custom resources) are returned as
+// unstructured.Unstructured.
+func ToObjectWithScheme(res *resource.Resource, scheme *runtime.Scheme) (client.Object, error) {
+	rawjson, err := res.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling resource: %w", err)
+	}
 
-	case resid.Gvk{
-		Group:   "autoscaling",
-		Version: "v2beta2",
-		Kind:    "HorizontalPodAutoscaler",
-	}:
-		obj = &asclv1.HorizontalPodAutoscaler{}
+	rgvk := res.GetGvk()
+	gvk := schema.GroupVersionKind{
+		Group:   rgvk.Group,
+		Version: rgvk.Version,
+		Kind:    rgvk.Kind,
+	}
 
-	default:
-		return nil, fmt.Errorf("unmapped type %+v", res.GetGvk())
+	if !scheme.Recognizes(gvk) {
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(rawjson); err != nil {
+			return nil, fmt.Errorf("error unmarshaling %s: %w", gvk, err)
+		}
+		return obj, nil
 	}
 
-	rawjson, err := res.MarshalJSON()
+	robj, err := scheme.New(gvk)
 	if err != nil {
-		return nil, fmt.Errorf("error marshaling resource: %w", err)
+		return nil, fmt.Errorf("error creating %s: %w", gvk, err)
+	}
+
+	obj, ok := robj.(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a client object", gvk)
 	}
 
 	if err := json.Unmarshal(rawjson, obj); err != nil {
@@ -0,0 +1,113 @@
+package resource_test
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/kustomize/api/provider"
+
+	"github.com/ricardomaraschini/freighter/infra/resource"
+)
+
+const deployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: clair
+  namespace: test
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: clair
+        image: quay.io/projectquay/clair:4.3.0
+`
+
+const database = `
+apiVersion: example.com/v1
+kind: Database
+metadata:
+  name: clair
+spec:
+  engine: postgres
+`
+
+func TestToObject(t *testing.T) {
+	factory := provider.NewDefaultDepProvider().GetResourceFactory()
+
+	res, err := factory.FromBytes([]byte(deployment))
+	if err != nil {
+		t.Fatalf("error parsing deployment: %s", err)
+	}
+	obj, err := resource.ToObject(res)
+	if err != nil {
+		t.Fatalf("error converting deployment: %s", err)
+	}
+	dep, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		t.Fatalf("expected *appsv1.Deployment, got %T", obj)
+	}
+	if dep.Name != "clair" || dep.Namespace != "test" {
+		t.Errorf("unexpected object key %s/%s", dep.Namespace, dep.Name)
+	}
+	if dep.Spec.Replicas == nil || *dep.Spec.Replicas != 2 {
+		t.Errorf("replicas not preserved: %v", dep.Spec.Replicas)
+	}
+	if img := dep.Spec.Template.Spec.Containers[0].Image; img != "quay.io/projectquay/clair:4.3.0" {
+		t.Errorf("image not preserved: %q", img)
+	}
+
+	res, err = factory.FromBytes([]byte(database))
+	if err != nil {
+		t.Fatalf("error parsing custom resource: %s", err)
+	}
+	obj, err = resource.ToObject(res)
+	if err != nil {
+		t.Fatalf("error converting custom resource: %s", err)
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		t.Fatalf("expected *unstructured.Unstructured, got %T", obj)
+	}
+	if u.GetKind() != "Database" || u.GetName() != "clair" {
+		t.Errorf("unexpected object %s %s", u.GetKind(), u.GetName())
+	}
+	if engine, _, _ := unstructured.NestedString(u.Object, "spec", "engine"); engine != "postgres" {
+		t.Errorf("spec not preserved: %q", engine)
+	}
+}
+
+func TestToObjectWithScheme(t *testing.T) {
+	factory := provider.NewDefaultDepProvider().GetResourceFactory()
+	res, err := factory.FromBytes([]byte(deployment))
+	if err != nil {
+		t.Fatalf("error parsing deployment: %s", err)
+	}
+
+	obj, err := resource.ToObjectWithScheme(res, runtime.NewScheme())
+	if err != nil {
+		t.Fatalf("error converting deployment: %s", err)
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		t.Fatalf("expected *unstructured.Unstructured for empty scheme, got %T", obj)
+	}
+	replicas, _, _ := unstructured.NestedInt64(u.Object, "spec", "replicas")
+	if replicas != 2 {
+		t.Errorf("replicas not preserved: %d", replicas)
+	}
+
+	scheme := runtime.NewScheme()
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("error building scheme: %s", err)
+	}
+	if obj, err = resource.ToObjectWithScheme(res, scheme); err != nil {
+		t.Fatalf("error converting deployment: %s", err)
+	}
+	if _, ok := obj.(*appsv1.Deployment); !ok {
+		t.Errorf("expected *appsv1.Deployment for apps scheme, got %T", obj)
+	}
+}